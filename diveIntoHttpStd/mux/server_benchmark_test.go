@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+
+	http11server "github.com/kianooshaz/http-from-scratch/http1.1/server"
+	http1server "github.com/kianooshaz/http-from-scratch/http1/server"
+)
+
+// headersHandler answers with a handful of headers and a small body, the
+// kind of response where per-token writes hurt the most.
+func headersHandler(w http.ResponseWriter, r *http.Request) {
+	h := w.Header()
+	h.Set("Content-Type", "application/json")
+	h.Set("Cache-Control", "no-store")
+	h.Set("X-Request-Id", "0123456789abcdef")
+	h.Set("X-Frame-Options", "DENY")
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("Vary", "Accept-Encoding")
+	w.Write([]byte(`{"message":"hello world"}`))
+}
+
+// -------------------- Setup functions --------------------
+
+// listen starts serve on a loopback port and returns the address to dial
+func listen(b *testing.B, serve func(net.Listener) error) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { l.Close() })
+	go serve(l)
+	return l.Addr().String()
+}
+
+// -------------------- Benchmarks --------------------
+
+// BenchmarkHTTP1Server sends one HTTP/1.0 request per connection
+func BenchmarkHTTP1Server(b *testing.B) {
+	mux := setupFixed()
+	mux.HandleFunc("/headers", headersHandler)
+	s := &http1server.Server{Handler: mux}
+	addr := listen(b, s.Serve)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := io.WriteString(conn, "GET /headers HTTP/1.0\r\n\r\n"); err != nil {
+			b.Fatal(err)
+		}
+		n, err := io.Copy(io.Discard, conn)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.SetBytes(n)
+		conn.Close()
+	}
+}
+
+// BenchmarkHTTP11ServerKeepAlive sends sequential requests over one keep-alive connection
+func BenchmarkHTTP11ServerKeepAlive(b *testing.B) {
+	mux := setupFixed()
+	mux.HandleFunc("/headers", headersHandler)
+	s := &http11server.Server{Handler: mux}
+	addr := listen(b, s.Serve)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := io.WriteString(conn, "GET /headers HTTP/1.1\r\nHost: localhost\r\n\r\n"); err != nil {
+			b.Fatal(err)
+		}
+		resp, err := http.ReadResponse(br, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+			b.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+}
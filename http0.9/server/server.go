@@ -2,33 +2,55 @@ package server
 
 import (
 	"bufio"
-	"log"
 	"net"
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 )
 
+// Server serves HTTP/0.9. There is no IdleTimeout: HTTP/0.9 has no
+// keep-alive, the connection is closed after every response.
 type Server struct {
 	Addr    string
 	Handler http.Handler
+
+	// ReadTimeout is the maximum duration for reading the request line,
+	// which is the whole of an HTTP/0.9 request. Zero or negative means no
+	// timeout.
+	ReadTimeout time.Duration
+
+	// ReadHeaderTimeout is the amount of time allowed to read the request
+	// line. An HTTP/0.9 request has no headers, so the request line is held
+	// to whichever of ReadHeaderTimeout and ReadTimeout is shorter. If zero,
+	// ReadTimeout is used.
+	ReadHeaderTimeout time.Duration
+
+	// WriteTimeout is the maximum duration before timing out writes of the
+	// response, counted from the end of the request line.
+	WriteTimeout time.Duration
 }
 
 func (s *Server) ListenAndServe() error {
-	if s.Handler == nil {
-		s.Handler = http.DefaultServeMux
-	}
-
 	l, err := net.Listen("tcp", s.Addr)
 	if err != nil {
 		return err
 	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections on l and handles each in its own goroutine.
+// It always returns a non-nil error and closes l.
+func (s *Server) Serve(l net.Listener) error {
 	defer l.Close()
+	if s.Handler == nil {
+		s.Handler = http.DefaultServeMux
+	}
 
 	for {
 		conn, err := l.Accept()
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
 
 		go s.handleConnection(conn)
@@ -38,12 +60,20 @@ func (s *Server) ListenAndServe() error {
 func (s *Server) handleConnection(conn net.Conn) {
 	defer conn.Close()
 
+	if d := s.readTimeout(); d > 0 {
+		conn.SetReadDeadline(time.Now().Add(d))
+	}
+
 	reader := bufio.NewReader(conn)
 	line, _, err := reader.ReadLine()
 	if err != nil {
 		return
 	}
 
+	if d := s.WriteTimeout; d > 0 {
+		conn.SetWriteDeadline(time.Now().Add(d))
+	}
+
 	fields := strings.Fields(string(line))
 	if len(fields) < 2 {
 		return
@@ -59,3 +89,12 @@ func (s *Server) handleConnection(conn net.Conn) {
 
 	s.Handler.ServeHTTP(newWriter(conn), r)
 }
+
+// readTimeout is how long the request line may take.
+func (s *Server) readTimeout() time.Duration {
+	d := s.ReadTimeout
+	if h := s.ReadHeaderTimeout; h > 0 && (d <= 0 || h < d) {
+		d = h
+	}
+	return d
+}
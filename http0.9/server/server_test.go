@@ -0,0 +1,150 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+// startTestServer serves s on a loopback port and returns the address to
+// dial. Configure s before calling it.
+func startTestServer(t *testing.T, s *Server) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go s.Serve(l)
+	return l.Addr().String()
+}
+
+func dial(t *testing.T, addr string) net.Conn {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	// Fail instead of hanging when the server doesn't answer.
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return conn
+}
+
+func TestServe(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Method+" "+r.URL.Path+" "+r.Proto)
+	})}
+	errc := make(chan error, 1)
+	go func() { errc <- s.Serve(l) }()
+
+	conn := dial(t, l.Addr().String())
+	io.WriteString(conn, "GET /hello\r\n")
+	// No status line, no headers, the response ends when the server closes.
+	resp, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "GET /hello HTTP/0.9"; string(resp) != want {
+		t.Errorf("response = %q, want %q", resp, want)
+	}
+
+	l.Close()
+	select {
+	case err := <-errc:
+		if err == nil {
+			t.Error("Serve returned nil after the listener closed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve did not return after the listener closed")
+	}
+}
+
+// TestReadHeaderTimeout plays a slowloris client, sending the request line
+// a byte at a time and never finishing it.
+func TestReadHeaderTimeout(t *testing.T) {
+	addr := startTestServer(t, &Server{
+		ReadTimeout:       time.Minute,
+		ReadHeaderTimeout: 100 * time.Millisecond,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			t.Error("handler called for an unfinished request")
+		}),
+	})
+
+	conn := dial(t, addr)
+	start := time.Now()
+	go func() {
+		for {
+			if _, err := io.WriteString(conn, "G"); err != nil {
+				return
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}()
+
+	// Closed, or reset if our last byte was still unread.
+	if _, err := io.ReadAll(conn); errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatal("connection not closed by the server")
+	}
+	if d := time.Since(start); d < 100*time.Millisecond || d > time.Second {
+		t.Errorf("connection closed after %v, want about 100ms", d)
+	}
+}
+
+// TestWriteTimeout checks that writing to a client that stopped reading
+// fails instead of blocking the handler forever.
+func TestWriteTimeout(t *testing.T) {
+	errc := make(chan error, 1)
+	addr := startTestServer(t, &Server{
+		WriteTimeout: 100 * time.Millisecond,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			chunk := make([]byte, 1<<20)
+			for {
+				if _, err := w.Write(chunk); err != nil {
+					errc <- err
+					return
+				}
+			}
+		}),
+	})
+
+	conn := dial(t, addr)
+	io.WriteString(conn, "GET /\r\n")
+	select {
+	case err := <-errc:
+		if !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Errorf("Write = %v, want %v", err, os.ErrDeadlineExceeded)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("write to a stalled client did not time out")
+	}
+}
+
+func TestReadTimeout(t *testing.T) {
+	tests := []struct {
+		read, header, want time.Duration
+	}{
+		{0, 0, 0},
+		{time.Second, 0, time.Second},
+		{0, time.Second, time.Second},
+		{time.Second, 2 * time.Second, time.Second},
+		{2 * time.Second, time.Second, time.Second},
+	}
+	for _, tt := range tests {
+		s := &Server{ReadTimeout: tt.read, ReadHeaderTimeout: tt.header}
+		if got := s.readTimeout(); got != tt.want {
+			t.Errorf("ReadTimeout %v, ReadHeaderTimeout %v: got %v, want %v", tt.read, tt.header, got, tt.want)
+		}
+	}
+}
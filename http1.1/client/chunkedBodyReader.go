@@ -0,0 +1,147 @@
+package client
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/kianooshaz/http-from-scratch/internal/parser"
+)
+
+const (
+	// maxChunkLineLength caps a chunk-size line, extensions included. Same
+	// as in net/http.
+	maxChunkLineLength = 4096
+	// maxTrailerBytes caps the trailer section, CRLFs included.
+	maxTrailerBytes = 64 << 10
+)
+
+type chunkedBodyReader struct {
+	reader         *bufio.Reader
+	resp           *http.Response // gets the trailers once the body is done
+	bytesRemaining int64          // bytes left in current chunk
+	stickyErr      error          // persistent read error
+}
+
+func (r *chunkedBodyReader) Read(p []byte) (int, error) {
+	if r.stickyErr != nil {
+		return 0, r.stickyErr
+	}
+
+	// Need a new chunk?
+	if r.bytesRemaining == 0 {
+		size, err := r.nextChunkSize()
+		if err != nil {
+			r.stickyErr = noEOF(err)
+			return 0, r.stickyErr
+		}
+		r.bytesRemaining = size
+	}
+
+	// Last chunk (size 0) => EOF. Make it sticky so we never read past the
+	// body into the next response on the connection.
+	if r.bytesRemaining == 0 {
+		r.stickyErr = io.EOF
+		return 0, io.EOF
+	}
+
+	// Limit read to remaining chunk size
+	if int64(len(p)) > r.bytesRemaining {
+		p = p[:r.bytesRemaining]
+	}
+
+	n, err := r.reader.Read(p)
+	r.bytesRemaining -= int64(n)
+	err = noEOF(err)
+
+	// If chunk ended, consume trailing CRLF
+	if r.bytesRemaining == 0 && err == nil {
+		if err := r.consumeCRLF(); err != nil {
+			r.stickyErr = noEOF(err)
+			return n, r.stickyErr
+		}
+	}
+
+	r.stickyErr = err
+	return n, err
+}
+
+// noEOF turns io.EOF into io.ErrUnexpectedEOF. The body only ends with the
+// last chunk, a connection that ends before it is cut short.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func (r *chunkedBodyReader) nextChunkSize() (int64, error) {
+	line, err := parser.ReadLineLimit(r.reader, maxChunkLineLength)
+	if err == parser.ErrLineTooLong {
+		return 0, errors.New("chunk line too long")
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	// Extensions are checked but not kept.
+	size, _, err := parser.ParseChunkLine(line)
+	if err != nil {
+		return 0, err
+	}
+
+	// Final chunk → read trailers
+	if size == 0 {
+		if err := r.readTrailers(); err != nil {
+			return 0, err
+		}
+	}
+
+	return size, nil
+}
+
+// readTrailers reads the trailer section after the last chunk into
+// resp.Trailer.
+func (r *chunkedBodyReader) readTrailers() error {
+	remain := maxTrailerBytes
+	for {
+		line, err := parser.ReadLineLimit(r.reader, max(remain-2, 0))
+		if err == parser.ErrLineTooLong {
+			return errors.New("trailer too large")
+		}
+		if err != nil {
+			return err
+		}
+		if len(line) == 0 {
+			return nil
+		}
+		remain -= len(line) + 2
+
+		k, v, err := parser.ParseHeaderField(line)
+		if err != nil {
+			return fmt.Errorf("invalid trailer: %w", err)
+		}
+		if r.resp.Trailer == nil {
+			r.resp.Trailer = make(http.Header)
+		}
+		r.resp.Trailer.Add(k, v)
+	}
+}
+
+func (r *chunkedBodyReader) consumeCRLF() error {
+	if b, err := r.reader.ReadByte(); err != nil || b != '\r' {
+		if err != nil {
+			return err
+		}
+		return errors.New("missing CR after chunk")
+	}
+	if b, err := r.reader.ReadByte(); err != nil || b != '\n' {
+		if err != nil {
+			return err
+		}
+		return errors.New("missing LF after chunk")
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package client
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestChunkedBody(t *testing.T) {
+	resp := new(http.Response)
+	r := &chunkedBodyReader{
+		reader: bufio.NewReader(strings.NewReader("5;sig=abc\r\nhello\r\n0\r\nX-Sum: 5\r\n\r\nnext")),
+		resp:   resp,
+	}
+	body, err := io.ReadAll(r)
+	if err != nil || string(body) != "hello" {
+		t.Fatalf("got %q, %v, want %q", body, err, "hello")
+	}
+	if got := resp.Trailer.Get("X-Sum"); got != "5" {
+		t.Errorf("trailer X-Sum = %q, want %q", got, "5")
+	}
+	// The next response must be left untouched.
+	if rest, _ := io.ReadAll(r.reader); string(rest) != "next" {
+		t.Errorf("left %q, want %q", rest, "next")
+	}
+}
+
+func TestChunkedBodyLimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		wantErr string
+	}{
+		{"long chunk line", "5;x=" + strings.Repeat("a", maxChunkLineLength) + "\r\nhello\r\n0\r\n\r\n", "chunk line too long"},
+		{"size overflow", "8000000000000000\r\n", "chunk size overflows"},
+		{"large trailer", "0\r\n" + strings.Repeat("X-Pad: "+strings.Repeat("a", 1000)+"\r\n", 70) + "\r\n", "trailer too large"},
+		{"cut short", "5\r\nhello\r\n", "unexpected EOF"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &chunkedBodyReader{reader: bufio.NewReader(strings.NewReader(tt.body)), resp: new(http.Response)}
+			_, err := io.ReadAll(r)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestChunkedBodyStrict feeds chunk lines and trailers that net/http
+// rejects, the same the server's decoder turns down.
+func TestChunkedBodyStrict(t *testing.T) {
+	for _, body := range []string{
+		"+5\r\nhello\r\n0\r\n\r\n",
+		"-0\r\n\r\n",
+		" 5\r\nhello\r\n0\r\n\r\n",
+		"5 \r\nhello\r\n0\r\n\r\n",
+		"0x5\r\nhello\r\n0\r\n\r\n",
+		"5\nhello\r\n0\r\n\r\n",
+		"5\r\nhello\r\n0\r\nX-Sum: 1\n\r\n",
+		"5\r\nhello\r\n0\r\nX-Sum : 1\r\n\r\n",
+		"5\r\nhello\r\n0\r\nX-Sum: 1\r\n 2\r\n\r\n",
+	} {
+		r := &chunkedBodyReader{reader: bufio.NewReader(strings.NewReader(body)), resp: new(http.Response)}
+		if _, err := io.ReadAll(r); err == nil {
+			t.Errorf("%q: read without an error", body)
+		}
+	}
+}
@@ -0,0 +1,393 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMaxIdleConnsPerHost is used when Client.MaxIdleConnsPerHost is zero.
+const DefaultMaxIdleConnsPerHost = 2
+
+// Client is an HTTP/1.1 client that keeps finished keep-alive connections
+// in a per-host pool and reuses them for later requests.
+type Client struct {
+	// MaxIdleConnsPerHost is the maximum number of idle connections kept
+	// per host. If zero, DefaultMaxIdleConnsPerHost is used. A negative
+	// value disables connection reuse.
+	MaxIdleConnsPerHost int
+
+	// IdleConnTimeout is how long an idle connection stays in the pool
+	// before it is closed, whether or not another request comes along. Zero
+	// means no limit.
+	IdleConnTimeout time.Duration
+
+	// DialContext is used to open new connections. If nil, net.Dialer is used.
+	DialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	mu   sync.Mutex
+	idle map[string][]*persistConn
+}
+
+// persistConn is a connection that may carry several requests.
+type persistConn struct {
+	client *Client
+	key    string
+	conn   net.Conn
+	br     *bufio.Reader
+	bw     *bufio.Writer
+	idleAt time.Time
+	// idleTimer closes the connection once it has been idle for
+	// IdleConnTimeout.
+	idleTimer *time.Timer
+	reused    bool
+	nwrite    int64 // bytes written to conn
+
+	// stopWatch stops closing the connection when the request's context is
+	// done. It reports false if that already happened.
+	stopWatch func() bool
+}
+
+// persistConnWriter counts what reaches the connection, so a failed
+// request can tell whether the server may have seen any of it.
+type persistConnWriter struct {
+	pc *persistConn
+}
+
+func (w persistConnWriter) Write(p []byte) (int, error) {
+	n, err := w.pc.conn.Write(p)
+	w.pc.nwrite += int64(n)
+	return n, err
+}
+
+// nothingWrittenError is a request error from before any of the request
+// was written to the connection.
+type nothingWrittenError struct {
+	error
+}
+
+func (e nothingWrittenError) Unwrap() error { return e.error }
+
+// Do sends req and returns the response. The caller must close the
+// response body; once the body has been read to EOF the connection goes
+// back to the pool.
+//
+// The request's context covers the exchange up to the end of the body:
+// once it is done the connection is closed and the context's error is
+// returned.
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	if req.URL == nil {
+		return nil, errors.New("http: nil Request.URL")
+	}
+	if req.URL.Scheme != "http" {
+		return nil, fmt.Errorf("http: unsupported protocol scheme %q", req.URL.Scheme)
+	}
+	if req.URL.Host == "" {
+		return nil, errors.New("http: no Host in request URL")
+	}
+
+	for {
+		pc, err := c.getConn(req)
+		if err != nil {
+			return nil, closeBody(req, err)
+		}
+
+		resp, err := pc.roundTrip(req)
+		if err == nil {
+			return resp, nil
+		}
+		pc.conn.Close()
+
+		// The server may have closed a pooled connection while it sat idle.
+		// That is only safe to retry when nothing came back, the body can
+		// be sent again, and the server can't have acted on the request.
+		if !pc.reused || !canRetry(req, err) {
+			return nil, closeBody(req, err)
+		}
+		if req.GetBody != nil {
+			if req.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// CloseIdleConnections closes every pooled connection.
+func (c *Client) CloseIdleConnections() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, conns := range c.idle {
+		for _, pc := range conns {
+			if pc.idleTimer != nil {
+				pc.idleTimer.Stop()
+			}
+			pc.conn.Close()
+		}
+		delete(c.idle, key)
+	}
+}
+
+func (c *Client) getConn(req *http.Request) (*persistConn, error) {
+	key := hostPort(req.URL.Host)
+	if pc := c.getIdleConn(key); pc != nil {
+		return pc, nil
+	}
+
+	dial := c.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	conn, err := dial(req.Context(), "tcp", key)
+	if err != nil {
+		return nil, err
+	}
+	pc := &persistConn{
+		client: c,
+		key:    key,
+		conn:   conn,
+		br:     bufio.NewReader(conn),
+	}
+	pc.bw = bufio.NewWriter(persistConnWriter{pc})
+	return pc, nil
+}
+
+func (c *Client) getIdleConn(key string) *persistConn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	conns := c.idle[key]
+	for len(conns) > 0 {
+		// Most recently used first, it's the least likely to be stale.
+		pc := conns[len(conns)-1]
+		conns = conns[:len(conns)-1]
+		if pc.idleTimer != nil {
+			pc.idleTimer.Stop()
+		}
+		if c.IdleConnTimeout > 0 && time.Since(pc.idleAt) > c.IdleConnTimeout {
+			pc.conn.Close()
+			continue
+		}
+		c.idle[key] = conns
+		pc.reused = true
+		return pc
+	}
+	delete(c.idle, key)
+	return nil
+}
+
+// putIdleConn returns pc to the pool, or closes it if the pool is full.
+func (c *Client) putIdleConn(pc *persistConn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	max := c.MaxIdleConnsPerHost
+	if max == 0 {
+		max = DefaultMaxIdleConnsPerHost
+	}
+	if len(c.idle[pc.key]) >= max {
+		pc.conn.Close()
+		return
+	}
+	if c.idle == nil {
+		c.idle = make(map[string][]*persistConn)
+	}
+	pc.idleAt = time.Now()
+	c.idle[pc.key] = append(c.idle[pc.key], pc)
+	if c.IdleConnTimeout > 0 {
+		pc.idleTimer = time.AfterFunc(c.IdleConnTimeout, func() { c.closeIdleConn(pc) })
+	}
+}
+
+// closeIdleConn closes pc if it is still in the pool.
+func (c *Client) closeIdleConn(pc *persistConn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	conns := c.idle[pc.key]
+	i := slices.Index(conns, pc)
+	if i < 0 {
+		return
+	}
+	pc.conn.Close()
+	if conns = slices.Delete(conns, i, i+1); len(conns) == 0 {
+		delete(c.idle, pc.key)
+	} else {
+		c.idle[pc.key] = conns
+	}
+}
+
+func (pc *persistConn) roundTrip(req *http.Request) (*http.Response, error) {
+	// Closing the connection is what interrupts a blocked write or read
+	// once the context is cancelled or its deadline passes.
+	ctx := req.Context()
+	pc.stopWatch = context.AfterFunc(ctx, func() { pc.conn.Close() })
+
+	nwrite := pc.nwrite
+	err := writeRequest(pc.bw, req)
+	if err == nil {
+		err = pc.bw.Flush()
+	}
+	if err != nil {
+		pc.stopWatch()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if pc.nwrite == nwrite {
+			err = nothingWrittenError{err}
+		}
+		return nil, err
+	}
+
+	resp, err := readResponse(pc.br, req)
+	if err != nil {
+		pc.stopWatch()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+
+	// Like net/http, the body of a 101 response is the connection itself,
+	// for the caller to speak the new protocol on. The context only covers
+	// the handshake.
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		if !pc.stopWatch() {
+			pc.conn.Close()
+			return nil, ctx.Err()
+		}
+		resp.Body = &switchedBody{br: pc.br, conn: pc.conn}
+		return resp, nil
+	}
+
+	keepAlive := !req.Close && !resp.Close
+	if resp.Body == http.NoBody {
+		pc.release(keepAlive)
+		return resp, nil
+	}
+	resp.Body = &responseBody{
+		body:      resp.Body,
+		ctx:       ctx,
+		pc:        pc,
+		keepAlive: keepAlive,
+	}
+	return resp, nil
+}
+
+// release hands the connection back to the pool or closes it.
+func (pc *persistConn) release(keepAlive bool) {
+	if pc.stopWatch != nil && !pc.stopWatch() {
+		// The context closed the connection.
+		keepAlive = false
+	}
+	pc.stopWatch = nil
+	if keepAlive {
+		pc.client.putIdleConn(pc)
+		return
+	}
+	pc.conn.Close()
+}
+
+// responseBody releases the connection once the body has been consumed.
+type responseBody struct {
+	body      io.Reader
+	ctx       context.Context
+	pc        *persistConn
+	keepAlive bool
+	done      bool
+}
+
+func (b *responseBody) Read(p []byte) (int, error) {
+	if b.done {
+		return 0, io.EOF
+	}
+	n, err := b.body.Read(p)
+	if err == io.EOF {
+		b.done = true
+		b.pc.release(b.keepAlive)
+	} else if err != nil {
+		b.done = true
+		b.pc.release(false)
+		if b.ctx.Err() != nil {
+			err = b.ctx.Err()
+		}
+	}
+	return n, err
+}
+
+// Close closes the body. A body that wasn't read to the end leaves the
+// connection in an unknown state, so it is closed rather than reused.
+func (b *responseBody) Close() error {
+	if b.done {
+		return nil
+	}
+	b.done = true
+	b.pc.release(false)
+	return nil
+}
+
+// switchedBody is the body of a 101 response. It reads what the server sends
+// in the new protocol, starting with anything already buffered, and writes
+// to the server. Closing it closes the connection.
+type switchedBody struct {
+	br   *bufio.Reader
+	conn net.Conn
+}
+
+func (b *switchedBody) Read(p []byte) (int, error)  { return b.br.Read(p) }
+func (b *switchedBody) Write(p []byte) (int, error) { return b.conn.Write(p) }
+func (b *switchedBody) Close() error                { return b.conn.Close() }
+
+// canRetry reports whether req can be sent again on a new connection after
+// err on a reused one. Like net/http, a request that may have reached the
+// server is only sent again if it is idempotent: a server that acted on a
+// POST and then dropped the connection would see it twice.
+func canRetry(req *http.Request, err error) bool {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+	if errors.As(err, new(nothingWrittenError)) {
+		return true
+	}
+	if !isIdempotent(req) {
+		return false
+	}
+	// io.EOF means the connection closed before any of the response arrived.
+	if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+		return true
+	}
+	// So does a reset or broken pipe while we were still writing.
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "write" && !opErr.Timeout()
+}
+
+// isIdempotent reports whether sending req twice has the same effect as
+// sending it once, by its method or an Idempotency-Key header.
+func isIdempotent(req *http.Request) bool {
+	switch req.Method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	_, ok := req.Header["Idempotency-Key"]
+	_, xok := req.Header["X-Idempotency-Key"]
+	return ok || xok
+}
+
+func closeBody(req *http.Request, err error) error {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	return err
+}
+
+// hostPort adds the default port to a URL host that has none.
+func hostPort(host string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(strings.Trim(host, "[]"), "80")
+}
@@ -0,0 +1,408 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestServer starts a net/http server and counts the connections made to it.
+func newTestServer(t *testing.T, h http.Handler) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+
+	var conns atomic.Int32
+	ts := httptest.NewUnstartedServer(h)
+	ts.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	ts.Start()
+	t.Cleanup(ts.Close)
+	return ts, &conns
+}
+
+func get(t *testing.T, c *Client, url string) (*http.Response, string) {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(body)
+}
+
+func TestClientBodies(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/length", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "5")
+		io.WriteString(w, "hello")
+	})
+	mux.HandleFunc("/chunked", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "X-Sum")
+		io.WriteString(w, "hel")
+		w.(http.Flusher).Flush()
+		io.WriteString(w, "lo")
+		w.Header().Set("X-Sum", "5")
+	})
+	mux.HandleFunc("/close", func(w http.ResponseWriter, r *http.Request) {
+		// An HTTP/1.0 style response without any framing.
+		conn, bufrw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		bufrw.WriteString("HTTP/1.1 200 OK\r\n\r\nhello")
+		bufrw.Flush()
+	})
+	mux.HandleFunc("/empty", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	ts, _ := newTestServer(t, mux)
+	c := &Client{}
+
+	tests := []struct {
+		path          string
+		wantBody      string
+		wantLength    int64
+		wantChunked   bool
+		wantConnClose bool
+	}{
+		{path: "/length", wantBody: "hello", wantLength: 5},
+		{path: "/chunked", wantBody: "hello", wantLength: -1, wantChunked: true},
+		{path: "/close", wantBody: "hello", wantLength: -1, wantConnClose: true},
+		{path: "/empty", wantBody: "", wantLength: -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			resp, body := get(t, c, ts.URL+tt.path)
+			if body != tt.wantBody {
+				t.Errorf("body = %q, want %q", body, tt.wantBody)
+			}
+			if resp.ContentLength != tt.wantLength {
+				t.Errorf("ContentLength = %d, want %d", resp.ContentLength, tt.wantLength)
+			}
+			if chunked := len(resp.TransferEncoding) > 0; chunked != tt.wantChunked {
+				t.Errorf("chunked = %v, want %v", chunked, tt.wantChunked)
+			}
+			if resp.Close != tt.wantConnClose {
+				t.Errorf("Close = %v, want %v", resp.Close, tt.wantConnClose)
+			}
+		})
+	}
+
+	resp, _ := get(t, c, ts.URL+"/chunked")
+	if got := resp.Trailer.Get("X-Sum"); got != "5" {
+		t.Errorf("trailer X-Sum = %q, want %q", got, "5")
+	}
+}
+
+func TestClientRequestBodies(t *testing.T) {
+	ts, _ := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		io.WriteString(w, strings.Join(r.TransferEncoding, ",")+" "+string(body))
+	}))
+	c := &Client{}
+
+	// strings.Reader gets a ContentLength from NewRequest, a plain io.Reader doesn't.
+	for body, want := range map[io.Reader]string{
+		strings.NewReader("hello"):                 " hello",
+		io.MultiReader(strings.NewReader("hello")): "chunked hello",
+	} {
+		req, err := http.NewRequest(http.MethodPost, ts.URL, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := c.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(got) != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+}
+
+func TestClientReusesConnections(t *testing.T) {
+	ts, conns := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	c := &Client{}
+
+	for range 5 {
+		get(t, c, ts.URL)
+	}
+	if n := conns.Load(); n != 1 {
+		t.Errorf("opened %d connections for sequential requests, want 1", n)
+	}
+}
+
+func TestClientMaxIdleConnsPerHost(t *testing.T) {
+	release := make(chan struct{})
+	ts, conns := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		io.WriteString(w, "ok")
+	}))
+	c := &Client{MaxIdleConnsPerHost: 1}
+
+	// Three requests in flight at once need three connections, only one of
+	// them may stay in the pool afterwards.
+	done := make(chan struct{})
+	for range 3 {
+		go func() {
+			defer func() { done <- struct{}{} }()
+			get(t, c, ts.URL)
+		}()
+	}
+	for conns.Load() < 3 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	for range 3 {
+		<-done
+	}
+
+	c.mu.Lock()
+	idle := len(c.idle[ts.Listener.Addr().String()])
+	c.mu.Unlock()
+	if idle != 1 {
+		t.Errorf("%d idle connections, want 1", idle)
+	}
+}
+
+func TestClientIdleConnTimeout(t *testing.T) {
+	ts, conns := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	var first *closeRecorder
+	c := &Client{
+		IdleConnTimeout: 10 * time.Millisecond,
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+			if err != nil || first != nil {
+				return conn, err
+			}
+			first = &closeRecorder{Conn: conn}
+			return first, nil
+		},
+	}
+
+	get(t, c, ts.URL)
+	time.Sleep(50 * time.Millisecond)
+	// Closed even though no other request came along for it.
+	if !first.closed.Load() {
+		t.Error("idle connection still open after IdleConnTimeout")
+	}
+	get(t, c, ts.URL)
+	if n := conns.Load(); n != 2 {
+		t.Errorf("opened %d connections, want 2 after the idle one expired", n)
+	}
+}
+
+// closeRecorder remembers whether the connection was closed.
+type closeRecorder struct {
+	net.Conn
+	closed atomic.Bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed.Store(true)
+	return c.Conn.Close()
+}
+
+func TestClientRetriesStaleConnection(t *testing.T) {
+	// A server that closes every connection right after one response
+	// without saying so, like a keep-alive server hitting its idle timeout.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				if _, err := http.ReadRequest(bufio.NewReader(conn)); err != nil {
+					return
+				}
+				io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok")
+			}()
+		}
+	}()
+
+	c := &Client{}
+	for range 3 {
+		if _, body := get(t, c, "http://"+l.Addr().String()); body != "ok" {
+			t.Errorf("body = %q, want %q", body, "ok")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestClientDoesNotRetryPost(t *testing.T) {
+	// A server that reads a POST, maybe acting on it, and drops the
+	// connection before answering.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	var posts atomic.Int32
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				br := bufio.NewReader(conn)
+				for {
+					req, err := http.ReadRequest(br)
+					if err != nil {
+						return
+					}
+					io.Copy(io.Discard, req.Body)
+					if req.Method == http.MethodPost {
+						posts.Add(1)
+						return
+					}
+					io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok")
+				}
+			}()
+		}
+	}()
+
+	url := "http://" + l.Addr().String()
+	c := &Client{}
+	get(t, c, url)
+
+	req, err := http.NewRequest(http.MethodPost, url, strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Do(req); err == nil {
+		t.Fatal("Do succeeded, want an error")
+	}
+	if n := posts.Load(); n != 1 {
+		t.Errorf("server got %d POSTs, want 1", n)
+	}
+}
+
+func TestClientContext(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		// Until the client hangs up, or long enough to tell it didn't.
+		select {
+		case <-r.Context().Done():
+		case <-time.After(2 * time.Second):
+		}
+	})
+	mux.HandleFunc("/stream", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "start")
+		w.(http.Flusher).Flush()
+		select {
+		case <-r.Context().Done():
+		case <-time.After(2 * time.Second):
+		}
+	})
+	ts, _ := newTestServer(t, mux)
+	c := &Client{}
+
+	t.Run("response", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/slow", nil)
+		start := time.Now()
+		if _, err := c.Do(req); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Do = %v, want %v", err, context.DeadlineExceeded)
+		}
+		if d := time.Since(start); d > time.Second {
+			t.Errorf("Do returned after %v, want about 100ms", d)
+		}
+	})
+
+	t.Run("body", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/stream", nil)
+		resp, err := c.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		time.AfterFunc(50*time.Millisecond, cancel)
+		if _, err := io.ReadAll(resp.Body); !errors.Is(err, context.Canceled) {
+			t.Errorf("reading the body = %v, want %v", err, context.Canceled)
+		}
+	})
+}
+
+func TestClientSwitchingProtocols(t *testing.T) {
+	ts, _ := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Connection") != "Upgrade" || r.Header.Get("Upgrade") != "echo" {
+			http.Error(w, "upgrade required", http.StatusUpgradeRequired)
+			return
+		}
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		// The first line goes out with the 101, before the client writes.
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\nhello\n")
+		rw.Flush()
+		line, _ := rw.ReadString('\n')
+		rw.WriteString("echo: " + line)
+		rw.Flush()
+	}))
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "echo")
+	resp, err := (&Client{}).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusSwitchingProtocols)
+	}
+	rwc, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		t.Fatalf("101 body %T is not an io.ReadWriteCloser", resp.Body)
+	}
+	defer rwc.Close()
+
+	br := bufio.NewReader(rwc)
+	if line, _ := br.ReadString('\n'); line != "hello\n" {
+		t.Errorf("first line = %q, want %q", line, "hello\n")
+	}
+	io.WriteString(rwc, "ping\n")
+	if line, _ := br.ReadString('\n'); line != "echo: ping\n" {
+		t.Errorf("echo = %q, want %q", line, "echo: ping\n")
+	}
+}
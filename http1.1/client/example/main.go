@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/kianooshaz/http-from-scratch/http1.1/client"
+)
+
+func main() {
+	c := &client.Client{
+		MaxIdleConnsPerHost: 4,
+		IdleConnTimeout:     30 * time.Second,
+	}
+
+	// Both requests go over the same keep-alive connection.
+	for _, path := range []string{"/headers", "/status/418"} {
+		req, err := http.NewRequest(http.MethodGet, "http://127.0.0.1:9000"+path, nil)
+		if err != nil {
+			log.Fatalf("err: %s", err)
+		}
+
+		resp, err := c.Do(req)
+		if err != nil {
+			log.Fatalf("err: %s", err)
+		}
+
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			log.Fatalf("err: %s", err)
+		}
+
+		fmt.Println(resp.Status)
+		fmt.Println(string(body))
+	}
+}
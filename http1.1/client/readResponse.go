@@ -0,0 +1,156 @@
+package client
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// readResponse reads the next final response to req from br. Informational
+// (1xx) responses are skipped. An io.EOF is returned unwrapped only when the
+// connection was closed before any byte of the response arrived.
+func readResponse(br *bufio.Reader, req *http.Request) (*http.Response, error) {
+	tp := textproto.NewReader(br)
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return nil, err
+		}
+
+		resp := &http.Response{Request: req}
+
+		// Parse the status line: HTTP/1.1 200 OK
+		var found bool
+		resp.Proto, line, found = strings.Cut(line, " ")
+		if !found {
+			return nil, fmt.Errorf("malformed HTTP response %q", line)
+		}
+		if resp.ProtoMajor, resp.ProtoMinor, found = http.ParseHTTPVersion(resp.Proto); !found || resp.ProtoMajor != 1 {
+			return nil, fmt.Errorf("malformed HTTP version %q", resp.Proto)
+		}
+		code, reason, _ := strings.Cut(line, " ")
+		if len(code) != 3 {
+			return nil, fmt.Errorf("malformed HTTP status code %q", code)
+		}
+		if resp.StatusCode, err = strconv.Atoi(code); err != nil || resp.StatusCode < 100 {
+			return nil, fmt.Errorf("malformed HTTP status code %q", code)
+		}
+		resp.Status = code + " " + reason
+
+		hdr, err := tp.ReadMIMEHeader()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		resp.Header = http.Header(hdr)
+
+		// 100 Continue and friends come before the real response.
+		if resp.StatusCode < 200 && resp.StatusCode != http.StatusSwitchingProtocols {
+			continue
+		}
+
+		if err := setupBody(resp, br); err != nil {
+			return nil, err
+		}
+		return resp, nil
+	}
+}
+
+// setupBody works out how the body of resp is framed, following RFC 9112
+// section 6.3, and sets Body, ContentLength, TransferEncoding and Close.
+func setupBody(resp *http.Response, br *bufio.Reader) error {
+	resp.Close = shouldClose(resp)
+	resp.ContentLength = -1
+
+	cl := strings.TrimSpace(resp.Header.Get("Content-Length"))
+	if cl != "" {
+		n, err := strconv.ParseInt(cl, 10, 64)
+		if err != nil || n < 0 {
+			return fmt.Errorf("bad Content-Length %q", cl)
+		}
+		resp.ContentLength = n
+	}
+
+	switch {
+	case resp.Request.Method == http.MethodHead,
+		resp.StatusCode == http.StatusNoContent,
+		resp.StatusCode == http.StatusNotModified:
+		resp.Body = http.NoBody
+		return nil
+
+	case resp.StatusCode == http.StatusSwitchingProtocols:
+		// Whatever follows is no longer HTTP.
+		resp.Body = http.NoBody
+		resp.Close = true
+		return nil
+	}
+
+	if te := resp.Header.Get("Transfer-Encoding"); te != "" {
+		if !strings.EqualFold(strings.TrimSpace(te), "chunked") {
+			return fmt.Errorf("unsupported transfer encoding %q", te)
+		}
+		resp.TransferEncoding = []string{"chunked"}
+		resp.ContentLength = -1
+		resp.Header.Del("Content-Length")
+		resp.Body = io.NopCloser(&chunkedBodyReader{reader: br, resp: resp})
+		return nil
+	}
+
+	if resp.ContentLength == 0 {
+		resp.Body = http.NoBody
+		return nil
+	}
+	if resp.ContentLength > 0 {
+		resp.Body = io.NopCloser(&bodyReader{reader: io.LimitReader(br, resp.ContentLength), n: resp.ContentLength})
+		return nil
+	}
+
+	// No framing at all, the body ends when the server closes the connection.
+	resp.Close = true
+	resp.Body = io.NopCloser(br)
+	return nil
+}
+
+func shouldClose(resp *http.Response) bool {
+	conn := resp.Header["Connection"]
+	if resp.ProtoMinor == 0 {
+		return !headerValuesContainsToken(conn, "keep-alive")
+	}
+	return headerValuesContainsToken(conn, "close")
+}
+
+// headerValuesContainsToken reports whether any of the comma separated
+// values contains token, ignoring case.
+func headerValuesContainsToken(values []string, token string) bool {
+	for _, v := range values {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// bodyReader reads a body with a known length and reports a connection
+// closed before the end of it as io.ErrUnexpectedEOF.
+type bodyReader struct {
+	reader io.Reader
+	n      int64
+}
+
+func (r *bodyReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.n -= int64(n)
+	if err == io.EOF && r.n > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
@@ -0,0 +1,122 @@
+package client
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+var nlcf = []byte{0x0d, 0x0a}
+
+// headers written by writeRequest itself rather than copied from the request.
+var reqWriteExcludeHeader = map[string]bool{
+	"Host":              true,
+	"Content-Length":    true,
+	"Transfer-Encoding": true,
+}
+
+// writeRequest writes the request line, headers and body of req to w.
+// Write errors are sticky in the bufio.Writer and surface on Flush.
+func writeRequest(w *bufio.Writer, req *http.Request) error {
+	method := req.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+
+	io.WriteString(w, method)
+	w.WriteByte(' ')
+	io.WriteString(w, req.URL.RequestURI())
+	io.WriteString(w, " HTTP/1.1")
+	w.Write(nlcf)
+
+	io.WriteString(w, "Host: ")
+	io.WriteString(w, host)
+	w.Write(nlcf)
+
+	// A body without a known length is sent chunked. A zero ContentLength
+	// with a non-nil body means unknown, just like in net/http.
+	hasBody := req.Body != nil && req.Body != http.NoBody
+	chunked := hasBody && req.ContentLength <= 0
+	switch {
+	case chunked:
+		io.WriteString(w, "Transfer-Encoding: chunked")
+		w.Write(nlcf)
+	case hasBody || methodExpectsBody(method):
+		io.WriteString(w, "Content-Length: ")
+		io.WriteString(w, strconv.FormatInt(req.ContentLength, 10))
+		w.Write(nlcf)
+	}
+
+	// A Connection header of the caller, e.g. "Upgrade", is copied below.
+	if req.Close && !headerValuesContainsToken(req.Header["Connection"], "close") {
+		io.WriteString(w, "Connection: close")
+		w.Write(nlcf)
+	}
+
+	if err := req.Header.WriteSubset(w, reqWriteExcludeHeader); err != nil {
+		return err
+	}
+	w.Write(nlcf)
+
+	if !hasBody {
+		return nil
+	}
+	defer req.Body.Close()
+
+	if chunked {
+		cw := &chunkedWriter{w: w}
+		if _, err := io.Copy(cw, req.Body); err != nil {
+			return err
+		}
+		return cw.close()
+	}
+
+	n, err := io.Copy(w, io.LimitReader(req.Body, req.ContentLength))
+	if err != nil {
+		return err
+	}
+	if n != req.ContentLength {
+		return fmt.Errorf("http: ContentLength=%d with Body length %d", req.ContentLength, n)
+	}
+	return nil
+}
+
+func methodExpectsBody(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch:
+		return true
+	}
+	return false
+}
+
+// chunkedWriter frames every Write as one chunk.
+type chunkedWriter struct {
+	w *bufio.Writer
+}
+
+func (cw *chunkedWriter) Write(b []byte) (int, error) {
+	if len(b) == 0 {
+		// A zero-length chunk would end the body.
+		return 0, nil
+	}
+	io.WriteString(cw.w, strconv.FormatInt(int64(len(b)), 16))
+	cw.w.Write(nlcf)
+	n, err := cw.w.Write(b)
+	if err != nil {
+		return n, err
+	}
+	_, err = cw.w.Write(nlcf)
+	return n, err
+}
+
+// close writes the last chunk and an empty trailer section.
+func (cw *chunkedWriter) close() error {
+	_, err := io.WriteString(cw.w, "0\r\n\r\n")
+	return err
+}
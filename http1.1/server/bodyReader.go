@@ -1,21 +1,131 @@
 package server
 
-import "io"
+import (
+	"io"
+	"net/http"
+)
 
-type noBody struct{}
+// eofSignalBody calls onEOF the first time the handler reads body to the
+// end. Draining the body in Close doesn't count.
+type eofSignalBody struct {
+	body   io.ReadCloser
+	onEOF  func()
+	sawEOF bool
+}
 
-func (noBody) Read([]byte) (int, error) { return 0, io.EOF }
-func (noBody) Close() error             { return nil }
+func (b *eofSignalBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	if err == io.EOF && !b.sawEOF {
+		b.sawEOF = true
+		b.onEOF()
+	}
+	return n, err
+}
 
+func (b *eofSignalBody) Close() error {
+	return b.body.Close()
+}
+
+// bodyReader reads a body of n bytes. A connection that ends before that is
+// io.ErrUnexpectedEOF, not the end of the body.
 type bodyReader struct {
 	reader io.Reader
+	n      int64
 }
 
-func (r *bodyReader) Read(p []byte) (n int, err error) {
-	return r.reader.Read(p)
+func (r *bodyReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.n -= int64(n)
+	if err == io.EOF && r.n > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
 }
 
 func (r *bodyReader) Close() error {
-	_, err := io.Copy(io.Discard, r.reader)
+	_, err := io.Copy(io.Discard, r)
+	return err
+}
+
+// expectContinueReader wraps the body of a request sent with
+// "Expect: 100-continue" and tells the client to go ahead on the first Read.
+type expectContinueReader struct {
+	body         io.ReadCloser
+	w            *responseBodyWriter
+	sentContinue bool
+}
+
+func (r *expectContinueReader) Read(p []byte) (int, error) {
+	// Once the final response has started the client has its answer, a
+	// 100 Continue now would be read as part of our response.
+	if !r.sentContinue && !r.w.sentHeaders {
+		r.sentContinue = true
+		if _, err := io.WriteString(r.w.bufw, "HTTP/1.1 100 Continue\r\n\r\n"); err != nil {
+			return 0, err
+		}
+		if err := r.w.bufw.Flush(); err != nil {
+			return 0, err
+		}
+	}
+	return r.body.Read(p)
+}
+
+// Close discards the rest of the body, but only if the client was told to
+// send it. Otherwise there is nothing to discard and waiting would block.
+func (r *expectContinueReader) Close() error {
+	if !r.sentContinue {
+		return nil
+	}
+	return r.body.Close()
+}
+
+// bodyHeldBack reports whether body is still waiting for a 100 Continue.
+func bodyHeldBack(body io.ReadCloser) bool {
+	ecr, ok := body.(*expectContinueReader)
+	return ok && !ecr.sentContinue
+}
+
+// maxBytesBody is http.MaxBytesReader for a body of unknown length: reading
+// past limit bytes is an *http.MaxBytesError, and onHit is called so the
+// server can answer 413 and close the connection.
+type maxBytesBody struct {
+	body   io.ReadCloser
+	remain int64
+	limit  int64
+	onHit  func()
+	err    error // sticky
+}
+
+func (b *maxBytesBody) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	// Read one byte more than allowed to tell a body of exactly limit bytes
+	// from a longer one.
+	if int64(len(p))-1 > b.remain {
+		p = p[:b.remain+1]
+	}
+	n, err := b.body.Read(p)
+	if int64(n) <= b.remain {
+		b.remain -= int64(n)
+		b.err = err
+		return n, err
+	}
+	n = int(b.remain)
+	b.remain = 0
+	b.err = &http.MaxBytesError{Limit: b.limit}
+	b.onHit()
+	return n, b.err
+}
+
+// Close discards the rest of the body, up to the limit.
+func (b *maxBytesBody) Close() error {
+	if b.err != nil && b.err != io.EOF {
+		return b.err
+	}
+	_, err := io.Copy(io.Discard, b)
 	return err
 }
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestBodyReaderCutShort(t *testing.T) {
+	r := &bodyReader{reader: io.LimitReader(strings.NewReader("hel"), 5), n: 5}
+	body, err := io.ReadAll(r)
+	if string(body) != "hel" || err != io.ErrUnexpectedEOF {
+		t.Errorf("got %q, %v, want %q, %v", body, err, "hel", io.ErrUnexpectedEOF)
+	}
+}
+
+func TestExpectContinue(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/read", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	})
+	mux.HandleFunc("/ignore", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	})
+	mux.HandleFunc("/wrap", func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, 10)
+		w.WriteHeader(http.StatusForbidden)
+	})
+	_, addr := newTestServer(t, mux)
+
+	t.Run("body read", func(t *testing.T) {
+		conn := dial(t, addr)
+		br := bufio.NewReader(conn)
+		io.WriteString(conn, "POST /read HTTP/1.1\r\nHost: a\r\nExpect: 100-continue\r\nContent-Length: 5\r\n\r\n")
+
+		resp, err := http.ReadResponse(br, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusContinue {
+			t.Fatalf("status = %d, want 100 before sending the body", resp.StatusCode)
+		}
+
+		io.WriteString(conn, "hello")
+		resp, err = http.ReadResponse(br, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK || string(body) != "hello" {
+			t.Errorf("got %d %q, want 200 %q", resp.StatusCode, body, "hello")
+		}
+	})
+
+	t.Run("body ignored", func(t *testing.T) {
+		conn := dial(t, addr)
+		io.WriteString(conn, "POST /ignore HTTP/1.1\r\nHost: a\r\nExpect: 100-continue\r\nContent-Length: 5\r\n\r\n")
+
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("status = %d, want %d without a 100 Continue", resp.StatusCode, http.StatusForbidden)
+		}
+		if !resp.Close {
+			t.Error("connection kept alive with an unsent body outstanding")
+		}
+	})
+
+	// The server must not lose track of the held back body when the handler
+	// replaces r.Body.
+	t.Run("body wrapped and ignored", func(t *testing.T) {
+		conn := dial(t, addr)
+		io.WriteString(conn, "POST /wrap HTTP/1.1\r\nHost: a\r\nExpect: 100-continue\r\nContent-Length: 5\r\n\r\n")
+
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("status = %d, want %d without a 100 Continue", resp.StatusCode, http.StatusForbidden)
+		}
+		if !resp.Close {
+			t.Error("connection kept alive with an unsent body outstanding")
+		}
+	})
+
+	t.Run("unknown expectation", func(t *testing.T) {
+		conn := dial(t, addr)
+		io.WriteString(conn, "POST /read HTTP/1.1\r\nHost: a\r\nExpect: 200-ok\r\nContent-Length: 5\r\n\r\n")
+
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusExpectationFailed {
+			t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusExpectationFailed)
+		}
+	})
+}
@@ -1,24 +1,57 @@
 package server
 
 import (
+	"bufio"
 	"bytes"
 	"fmt"
 	"io"
 	"log/slog"
 	"net"
 	"net/http"
+	"slices"
 	"strconv"
+	"strings"
 )
 
 var nlcf = []byte{0x0d, 0x0a}
 
 type responseBodyWriter struct {
 	req             *http.Request
-	conn            net.Conn
-	sentHeaders     bool
+	reqBody         io.ReadCloser // the body we installed, the handler may replace req.Body
+	conn            *conn
+	bufw            *bufio.Writer // flushed at the end of the response and on Flush
+	wroteHeader     bool          // the handler picked a status
+	sentHeaders     bool          // the status line and headers are on the wire
 	headers         http.Header
+	headerSnapshot  http.Header // headers as of WriteHeader, what is sent
+	status          int
 	chunkedEncoding bool
-	bodyBuffer      *bytes.Buffer
+	closeAfterReply bool
+
+	// requestBodyLimitHit is set once the handler read past
+	// Server.MaxBodyBytes.
+	requestBodyLimitHit bool
+
+	// bodyBuffer holds the start of the body, up to bufferLimit bytes, so
+	// a handler that is done by then gets a Content-Length instead of
+	// chunked encoding.
+	bodyBuffer  *bytes.Buffer
+	bufferLimit int
+
+	// written counts the body bytes a HEAD handler tried to write, used for
+	// the Content-Length a GET would have carried.
+	written int64
+
+	// declaredTrailers are the keys listed in the Trailer header when the
+	// headers were sent. Their values are written after the last chunk.
+	declaredTrailers []string
+}
+
+// requestTooLarge is called when the request body goes over the limit. The
+// rest of it is never read, so the connection can't be reused.
+func (r *responseBodyWriter) requestTooLarge() {
+	r.requestBodyLimitHit = true
+	r.closeAfterReply = true
 }
 
 func (r *responseBodyWriter) Header() http.Header {
@@ -26,27 +59,61 @@ func (r *responseBodyWriter) Header() http.Header {
 }
 
 func (r *responseBodyWriter) Write(b []byte) (int, error) {
-	if !r.sentHeaders {
+	if r.conn.hijacked.Load() {
+		return 0, http.ErrHijacked
+	}
+	if !r.wroteHeader {
 		if r.headers.Get("Content-Type") == "" {
 			r.headers.Set("Content-Type", http.DetectContentType(b))
 		}
 		r.WriteHeader(http.StatusOK)
 	}
+	if !bodyAllowedForStatus(r.status) {
+		return 0, http.ErrBodyNotAllowed
+	}
+
+	// A HEAD response never has a body. Only count, so flush can still tell
+	// the client how long the body would have been.
+	if r.req.Method == http.MethodHead {
+		r.written += int64(len(b))
+		return len(b), nil
+	}
+
+	if !r.sentHeaders {
+		if r.bodyBuffer == nil {
+			r.bodyBuffer = new(bytes.Buffer)
+		}
+		if r.bodyBuffer.Len()+len(b) <= r.bufferLimit {
+			return r.bodyBuffer.Write(b)
+		}
+		if err := r.sendHeaders(); err != nil {
+			return 0, err
+		}
+	}
+	return r.writeBody(b)
+}
+
+// writeBody writes b to the connection, as a chunk if the body is chunked.
+func (r *responseBodyWriter) writeBody(b []byte) (int, error) {
+	// A zero-length chunk would end the body.
+	if len(b) == 0 {
+		return 0, nil
+	}
 
 	if r.chunkedEncoding {
 		chunkSize := fmt.Sprintf("%x\r\n", len(b))
-		if _, err := r.conn.Write([]byte(chunkSize)); err != nil {
+		if _, err := r.bufw.Write([]byte(chunkSize)); err != nil {
 			return 0, err
 		}
 	}
 
-	n, err := r.conn.Write(b)
+	n, err := r.bufw.Write(b)
 	if err != nil {
 		return n, err
 	}
 
 	if r.chunkedEncoding {
-		if _, err := r.conn.Write(nlcf); err != nil {
+		if _, err := r.bufw.Write(nlcf); err != nil {
 			return n, err
 		}
 	}
@@ -54,62 +121,247 @@ func (r *responseBodyWriter) Write(b []byte) (int, error) {
 	return n, nil
 }
 
+// Flush sends the headers, if they haven't gone out yet, and everything
+// written so far to the client. The body is sent chunked from here on, so
+// handlers can stream, e.g. server-sent events or long polling.
 func (r *responseBodyWriter) Flush() {
-	if !r.sentHeaders {
+	if err := r.FlushError(); err != nil {
+		slog.Error("Error flushing response", "err", err)
+	}
+}
+
+// FlushError is like Flush but returns the error, e.g. once the client has
+// gone away. http.ResponseController uses it in preference to Flush.
+func (r *responseBodyWriter) FlushError() error {
+	if r.conn.hijacked.Load() {
+		return http.ErrHijacked
+	}
+	if !r.wroteHeader {
 		r.WriteHeader(http.StatusOK)
 	}
-	if flusher, ok := r.conn.(interface{ Flush() error }); ok {
-		flusher.Flush()
+	if !r.sentHeaders {
+		if err := r.sendHeaders(); err != nil {
+			return err
+		}
 	}
+	return r.bufw.Flush()
 }
 
+// Hijack lets the handler take over the connection, e.g. for a WebSocket
+// or a CONNECT tunnel. A status already set with WriteHeader is sent first.
+// The returned reader holds whatever the client sent that we haven't handed
+// out yet. The server neither uses nor closes the connection afterwards,
+// deadlines already set on it stay in place.
+func (r *responseBodyWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if r.conn.hijacked.Load() {
+		return nil, nil, http.ErrHijacked
+	}
+	if r.wroteHeader && !r.sentHeaders {
+		if err := r.sendHeaders(); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := r.bufw.Flush(); err != nil {
+		return nil, nil, err
+	}
+	return r.conn.hijack()
+}
+
+// flush finishes the response once the handler has returned.
 func (r *responseBodyWriter) flush() error {
-	if r.chunkedEncoding {
-		if _, err := r.conn.Write([]byte("0\r\n\r\n")); err != nil {
+	if !r.wroteHeader {
+		r.WriteHeader(http.StatusOK)
+	}
+
+	if !r.sentHeaders {
+		// The handler is done, so whatever it wrote is the whole body.
+		_, clSet := r.headerSnapshot["Content-Length"]
+		_, teSet := r.headerSnapshot["Transfer-Encoding"]
+		if !clSet && !teSet && !r.hasTrailers() && bodyAllowedForStatus(r.status) {
+			if r.req.Method != http.MethodHead {
+				n := 0
+				if r.bodyBuffer != nil {
+					n = r.bodyBuffer.Len()
+				}
+				r.headerSnapshot.Set("Content-Length", strconv.Itoa(n))
+			} else if r.written > 0 {
+				r.headerSnapshot.Set("Content-Length", strconv.FormatInt(r.written, 10))
+			}
+		}
+		if err := r.sendHeaders(); err != nil {
 			return err
 		}
 	}
 
-	r.writeBufferedBody()
+	if r.chunkedEncoding {
+		if _, err := r.bufw.Write([]byte("0\r\n")); err != nil {
+			return err
+		}
+		if err := writeFields(r.bufw, r.trailers()); err != nil {
+			return err
+		}
+		if _, err := r.bufw.Write(nlcf); err != nil {
+			return err
+		}
+	}
 
-	return nil
+	return r.bufw.Flush()
 }
 
+// WriteHeader records the status and a copy of the headers, later changes
+// only count for trailers. The headers themselves go out once the body
+// outgrows the buffer, on Flush, or when the handler returns.
 func (r *responseBodyWriter) WriteHeader(statusCode int) {
-	if r.sentHeaders {
+	if r.conn.hijacked.Load() {
+		slog.Warn(fmt.Sprintf("WriteHeader called on a hijacked connection with: %d", statusCode))
+		return
+	}
+	if r.wroteHeader {
 		slog.Warn(fmt.Sprintf("WriteHeader called twice, second time with: %d", statusCode))
 		return
 	}
 
-	r.writeHeader(r.conn, r.req.Proto, r.headers, statusCode)
+	// Informational responses go out right away and the handler still owes
+	// us a final one. 101 is final, the connection changes protocol after it.
+	if statusCode >= 100 && statusCode <= 199 && statusCode != http.StatusSwitchingProtocols {
+		if err := r.writeInformational(statusCode); err != nil {
+			slog.Error("Error writing informational response", "err", err)
+		}
+		return
+	}
+
+	r.wroteHeader = true
+	r.status = statusCode
+	r.headerSnapshot = r.headers.Clone()
+}
+
+// sendHeaders writes the status line and headers, then whatever part of
+// the body has been buffered.
+func (r *responseBodyWriter) sendHeaders() error {
 	r.sentHeaders = true
-	r.writeBufferedBody()
+	if err := r.writeHeader(r.bufw, r.req.Proto, r.headerSnapshot, r.status); err != nil {
+		return err
+	}
+	if r.bodyBuffer == nil {
+		return nil
+	}
+	buffered := r.bodyBuffer.Bytes()
+	r.bodyBuffer = nil
+	_, err := r.writeBody(buffered)
+	return err
 }
 
-func (r *responseBodyWriter) writeBufferedBody() {
-	if r.bodyBuffer != nil {
-		_, err := r.conn.Write(r.bodyBuffer.Bytes())
-		if err != nil {
-			slog.Error("Error writing buffered body", "err", err)
+// hasTrailers reports whether the handler announced trailers, which only
+// chunked encoding can carry.
+func (r *responseBodyWriter) hasTrailers() bool {
+	if len(r.headerSnapshot["Trailer"]) > 0 {
+		return true
+	}
+	for k := range r.headers {
+		if strings.HasPrefix(k, http.TrailerPrefix) {
+			return true
 		}
-		r.bodyBuffer = nil
 	}
+	return false
+}
+
+// writeInformational writes a 1xx response with the headers set so far,
+// e.g. the Link headers of a 103 Early Hints.
+func (r *responseBodyWriter) writeInformational(statusCode int) error {
+	// HTTP/1.0 clients don't know about 1xx responses.
+	if !r.req.ProtoAtLeast(1, 1) {
+		return nil
+	}
+	if err := writeStatusLine(r.bufw, r.req.Proto, statusCode); err != nil {
+		return err
+	}
+	if err := writeFields(r.bufw, r.headers); err != nil {
+		return err
+	}
+	if _, err := r.bufw.Write(nlcf); err != nil {
+		return err
+	}
+	// The client should see it now, not with the final response.
+	return r.bufw.Flush()
+}
+
+// bodyAllowedForStatus reports whether a response with the given status may
+// carry a body, see RFC 9112 section 6.3.
+func bodyAllowedForStatus(status int) bool {
+	switch {
+	case status >= 100 && status <= 199:
+		return false
+	case status == http.StatusNoContent:
+		return false
+	case status == http.StatusNotModified:
+		return false
+	}
+	return true
 }
 
 func (r *responseBodyWriter) writeHeader(conn io.Writer, proto string, headers http.Header, statusCode int) error {
-	_, clSet := r.headers["Content-Length"]
-	_, teSet := r.headers["Transfer-Encoding"]
-	if !clSet && !teSet {
+	_, clSet := headers["Content-Length"]
+	_, teSet := headers["Transfer-Encoding"]
+	switch {
+	case !bodyAllowedForStatus(statusCode):
+		// No body means no framing. A 304 may still tell the length of
+		// the representation it stands for.
+		headers.Del("Transfer-Encoding")
+		if statusCode != http.StatusNotModified {
+			headers.Del("Content-Length")
+		}
+	case clSet || teSet || r.req.Method == http.MethodHead:
+	case !r.req.ProtoAtLeast(1, 1):
+		// HTTP/1.0 has no chunked encoding, the body ends when we close.
+		r.closeAfterReply = true
+	default:
 		r.chunkedEncoding = true
-		r.headers.Set("Transfer-Encoding", "chunked")
+		headers.Set("Transfer-Encoding", "chunked")
 	}
 
-	if r.req.Close {
-		r.headers.Set("Connection", "close")
-	} else {
-		r.headers.Set("Connection", "keep-alive")
+	for _, v := range headers["Trailer"] {
+		for _, k := range strings.Split(v, ",") {
+			if k = strings.TrimSpace(k); k != "" {
+				r.declaredTrailers = append(r.declaredTrailers, http.CanonicalHeaderKey(k))
+			}
+		}
 	}
 
+	// The handler answered without reading a body the client is holding
+	// back. It may still send it, so we can't tell where the next request
+	// would start.
+	if bodyHeldBack(r.reqBody) {
+		r.closeAfterReply = true
+	}
+	// Shutdown may have started while the handler ran, the client should
+	// not send another request.
+	if r.conn.server.shuttingDown() {
+		r.closeAfterReply = true
+	}
+
+	switch {
+	case statusCode == http.StatusSwitchingProtocols:
+		// The handler's "Connection: Upgrade" stays, the connection is about
+		// to speak another protocol.
+	case r.closeAfterReply:
+		headers.Set("Connection", "close")
+	default:
+		headers.Set("Connection", "keep-alive")
+	}
+
+	if err := writeStatusLine(conn, proto, statusCode); err != nil {
+		return err
+	}
+	if err := writeFields(conn, headers, r.declaredTrailers...); err != nil {
+		return err
+	}
+	if _, err := conn.Write(nlcf); err != nil {
+		return err
+	}
+	return nil
+}
+
+func writeStatusLine(conn io.Writer, proto string, statusCode int) error {
 	if _, err := io.WriteString(conn, proto); err != nil {
 		return err
 	}
@@ -128,7 +380,34 @@ func (r *responseBodyWriter) writeHeader(conn io.Writer, proto string, headers h
 	if _, err := conn.Write(nlcf); err != nil {
 		return err
 	}
-	for k, vals := range headers {
+	return nil
+}
+
+// trailers collects the trailer fields to send after the last chunk: the
+// declared ones plus any set with the http.TrailerPrefix.
+func (r *responseBodyWriter) trailers() http.Header {
+	trailers := make(http.Header)
+	for _, k := range r.declaredTrailers {
+		if vals, ok := r.headers[k]; ok {
+			trailers[k] = vals
+		}
+	}
+	for k, vals := range r.headers {
+		if strings.HasPrefix(k, http.TrailerPrefix) {
+			trailers[http.CanonicalHeaderKey(strings.TrimPrefix(k, http.TrailerPrefix))] = vals
+		}
+	}
+	return trailers
+}
+
+// writeFields writes each header field as a "Key: value" line. Keys with
+// the http.TrailerPrefix are skipped, and so are the skip keys, they are
+// only sent as trailers.
+func writeFields(conn io.Writer, fields http.Header, skip ...string) error {
+	for k, vals := range fields {
+		if strings.HasPrefix(k, http.TrailerPrefix) || slices.Contains(skip, k) {
+			continue
+		}
 		for _, val := range vals {
 			if _, err := io.WriteString(conn, k); err != nil {
 				return err
@@ -144,8 +423,5 @@ func (r *responseBodyWriter) writeHeader(conn io.Writer, proto string, headers h
 			}
 		}
 	}
-	if _, err := conn.Write(nlcf); err != nil {
-		return err
-	}
 	return nil
 }
@@ -0,0 +1,310 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestResponseTrailers(t *testing.T) {
+	_, addr := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "X-Checksum")
+		io.WriteString(w, "hello")
+		w.Header().Set("X-Checksum", "abc123")
+		w.Header().Set(http.TrailerPrefix+"X-Late", "undeclared")
+		// Like in net/http, headers changed after the status is written
+		// don't go out.
+		w.Header().Set("X-After", "too late")
+	}))
+
+	conn := dial(t, addr)
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: a\r\n\r\n")
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "hello" {
+		t.Errorf("body = %q, want %q", body, "hello")
+	}
+	if got := resp.Trailer.Get("X-Checksum"); got != "abc123" {
+		t.Errorf("trailer X-Checksum = %q, want %q", got, "abc123")
+	}
+	if got := resp.Trailer.Get("X-Late"); got != "undeclared" {
+		t.Errorf("trailer X-Late = %q, want %q", got, "undeclared")
+	}
+	if _, ok := resp.Header[http.TrailerPrefix+"X-Late"]; ok {
+		t.Error("prefixed trailer key sent as a header")
+	}
+	if _, ok := resp.Header["X-Checksum"]; ok {
+		t.Error("declared trailer sent as a header")
+	}
+	if _, ok := resp.Header["X-After"]; ok {
+		t.Error("header set after the body was written got sent")
+	}
+}
+
+func TestHeadResponses(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/", http.FileServer(http.Dir("testdata")))
+	mux.HandleFunc("/written", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "<html>hello</html>")
+	})
+	_, addr := newTestServer(t, mux)
+
+	for _, path := range []string{"/hello.txt", "/written"} {
+		t.Run(path, func(t *testing.T) {
+			conn := dial(t, addr)
+			// If the HEAD response carried any body bytes the GET response
+			// would not parse.
+			io.WriteString(conn, "HEAD "+path+" HTTP/1.1\r\nHost: a\r\n\r\n"+
+				"GET "+path+" HTTP/1.1\r\nHost: a\r\nConnection: close\r\n\r\n")
+			br := bufio.NewReader(conn)
+
+			head, err := http.ReadResponse(br, &http.Request{Method: http.MethodHead})
+			if err != nil {
+				t.Fatal(err)
+			}
+			get, err := http.ReadResponse(br, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, err := io.ReadAll(get.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rest, _ := io.ReadAll(br); len(rest) != 0 {
+				t.Errorf("%d unexpected bytes after the GET response", len(rest))
+			}
+
+			if head.ContentLength != int64(len(body)) {
+				t.Errorf("HEAD Content-Length = %d, want %d", head.ContentLength, len(body))
+			}
+			if len(head.TransferEncoding) != 0 {
+				t.Errorf("HEAD Transfer-Encoding = %v, want none", head.TransferEncoding)
+			}
+			for _, k := range []string{"Content-Type", "Last-Modified", "Accept-Ranges"} {
+				if got, want := head.Header.Get(k), get.Header.Get(k); got != want {
+					t.Errorf("HEAD %s = %q, GET has %q", k, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestBodylessStatusFraming(t *testing.T) {
+	writeErrs := make(chan error, 1)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status/{status}", func(w http.ResponseWriter, r *http.Request) {
+		status, _ := strconv.Atoi(r.PathValue("status"))
+		// Framing headers a handler might have set anyway.
+		w.Header().Set("Content-Length", "5")
+		w.Header().Set("Transfer-Encoding", "chunked")
+		w.WriteHeader(status)
+		_, err := io.WriteString(w, "hello")
+		writeErrs <- err
+	})
+	mux.HandleFunc("/hints", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", "</style.css>; rel=preload")
+		w.WriteHeader(http.StatusEarlyHints)
+		io.WriteString(w, "final")
+	})
+	mux.HandleFunc("/next", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "next")
+	})
+	_, addr := newTestServer(t, mux)
+
+	tests := []struct {
+		status            int
+		wantContentLength bool
+	}{
+		{http.StatusNoContent, false},
+		{http.StatusNotModified, true},
+	}
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(tt.status), func(t *testing.T) {
+			conn := dial(t, addr)
+			// The response after a bodyless one only parses if the framing
+			// of the first was right.
+			io.WriteString(conn, "GET /status/"+strconv.Itoa(tt.status)+" HTTP/1.1\r\nHost: a\r\n\r\n"+
+				"GET /next HTTP/1.1\r\nHost: a\r\n\r\n")
+			br := bufio.NewReader(conn)
+
+			resp, err := http.ReadResponse(br, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.status {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.status)
+			}
+			if te := resp.Header.Get("Transfer-Encoding"); te != "" {
+				t.Errorf("Transfer-Encoding = %q, want none", te)
+			}
+			if _, ok := resp.Header["Content-Length"]; ok != tt.wantContentLength {
+				t.Errorf("Content-Length sent = %v, want %v", ok, tt.wantContentLength)
+			}
+			if err := <-writeErrs; err != http.ErrBodyNotAllowed {
+				t.Errorf("handler Write error = %v, want %v", err, http.ErrBodyNotAllowed)
+			}
+
+			next, err := http.ReadResponse(br, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if body, _ := io.ReadAll(next.Body); string(body) != "next" {
+				t.Errorf("next body = %q, want %q", body, "next")
+			}
+		})
+	}
+
+	t.Run("103", func(t *testing.T) {
+		conn := dial(t, addr)
+		io.WriteString(conn, "GET /hints HTTP/1.1\r\nHost: a\r\n\r\n")
+		br := bufio.NewReader(conn)
+
+		hints, err := http.ReadResponse(br, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hints.StatusCode != http.StatusEarlyHints || hints.Header.Get("Link") == "" {
+			t.Errorf("got %d with Link %q, want 103 with a Link header", hints.StatusCode, hints.Header.Get("Link"))
+		}
+		final, err := http.ReadResponse(br, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body, _ := io.ReadAll(final.Body); final.StatusCode != http.StatusOK || string(body) != "final" {
+			t.Errorf("got %d %q, want 200 %q", final.StatusCode, body, "final")
+		}
+	})
+}
+
+func TestResponseBuffering(t *testing.T) {
+	small := strings.Repeat("a", 100)
+	large := strings.Repeat("b", DefaultResponseBufferSize+1)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/small", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, small[:50])
+		io.WriteString(w, small[50:])
+	})
+	mux.HandleFunc("/large", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, large)
+	})
+	mux.HandleFunc("/headers", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("content-type", "application/json")
+		json.NewEncoder(w).Encode(r.Header)
+	})
+	mux.HandleFunc("/nothing", func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		name        string
+		bufferSize  int
+		proto       string
+		path        string
+		wantChunked bool
+		wantClose   bool
+	}{
+		{name: "small", path: "/small"},
+		{name: "json", path: "/headers"},
+		{name: "empty", path: "/nothing"},
+		{name: "large", path: "/large", wantChunked: true},
+		{name: "buffering disabled", bufferSize: -1, path: "/small", wantChunked: true},
+		{name: "large http/1.0", proto: "HTTP/1.0", path: "/large", wantClose: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr := startTestServer(t, &Server{Handler: mux, ResponseBufferSize: tt.bufferSize})
+			proto := tt.proto
+			if proto == "" {
+				proto = "HTTP/1.1"
+			}
+
+			conn := dial(t, addr)
+			io.WriteString(conn, "GET "+tt.path+" "+proto+"\r\nHost: a\r\n\r\n")
+			resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if chunked := len(resp.TransferEncoding) > 0; chunked != tt.wantChunked {
+				t.Errorf("chunked = %v, want %v", chunked, tt.wantChunked)
+			}
+			if !tt.wantChunked && !tt.wantClose && resp.ContentLength != int64(len(body)) {
+				t.Errorf("Content-Length = %d, want %d", resp.ContentLength, len(body))
+			}
+			if resp.Close != tt.wantClose {
+				t.Errorf("Close = %v, want %v", resp.Close, tt.wantClose)
+			}
+		})
+	}
+}
+
+func TestFlushStreamsChunks(t *testing.T) {
+	next := make(chan struct{})
+	_, addr := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, part := range []string{"one", "two"} {
+			io.WriteString(w, part)
+			w.(http.Flusher).Flush()
+			// Wait for the client, the part must be on the wire by now.
+			<-next
+		}
+		if err := http.NewResponseController(w).Flush(); err != nil {
+			t.Errorf("ResponseController.Flush: %v", err)
+		}
+	}))
+
+	conn := dial(t, addr)
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: a\r\n\r\n")
+	br := bufio.NewReader(conn)
+
+	var head []string
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading headers: %v", err)
+		}
+		if line == "\r\n" {
+			break
+		}
+		head = append(head, line)
+	}
+	if head[0] != "HTTP/1.1 200 OK\r\n" {
+		t.Errorf("status line = %q", head[0])
+	}
+	if !strings.Contains(strings.Join(head, ""), "Transfer-Encoding: chunked\r\n") {
+		t.Errorf("response is not chunked: %q", head)
+	}
+
+	for _, want := range []string{"3\r\none\r\n", "3\r\ntwo\r\n"} {
+		got := make([]byte, len(want))
+		if _, err := io.ReadFull(br, got); err != nil {
+			t.Fatalf("reading chunk %q: %v", want, err)
+		}
+		if string(got) != want {
+			t.Errorf("chunk = %q, want %q", got, want)
+		}
+		next <- struct{}{}
+	}
+
+	want := "0\r\n\r\n"
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(br, got); err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("last chunk = %q, want %q", got, want)
+	}
+}
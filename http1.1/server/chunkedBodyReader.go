@@ -3,15 +3,56 @@ package server
 import (
 	"bufio"
 	"errors"
+	"fmt"
 	"io"
-	"strconv"
+	"net/http"
 	"strings"
+
+	"github.com/kianooshaz/http-from-scratch/internal/parser"
+)
+
+const (
+	// maxChunkLineLength caps a chunk-size line, extensions included. Same
+	// as in net/http.
+	maxChunkLineLength = 4096
+	// maxChunkExtensionBytes caps the names and values of the extensions
+	// kept for one body.
+	maxChunkExtensionBytes = 64 << 10
+	// maxTrailerBytes caps the trailer section, CRLFs included.
+	maxTrailerBytes = 64 << 10
 )
 
+// ChunkExtension is an extension sent along with a chunk of a chunked
+// request body, see RFC 9112 section 7.1.1.
+type ChunkExtension struct {
+	// Chunk is the index of the chunk the extension came with. The last,
+	// empty chunk counts too.
+	Chunk int
+	Name  string
+	// Value is unquoted, empty if the extension has none.
+	Value string
+}
+
+type chunkExtensionsKey struct{}
+
+// ChunkExtensions returns the extensions of the chunks read so far from
+// the body of r, a request served by Server.
+func ChunkExtensions(r *http.Request) []ChunkExtension {
+	exts, _ := r.Context().Value(chunkExtensionsKey{}).(*[]ChunkExtension)
+	if exts == nil {
+		return nil
+	}
+	return *exts
+}
+
 type chunkedBodyReader struct {
 	reader         *bufio.Reader
-	bytesRemaining int64 // bytes left in current chunk
-	stickyErr      error // persistent read error
+	req            *http.Request     // gets the trailers once the body is done
+	exts           *[]ChunkExtension // gets the chunk extensions, if not nil
+	extBytes       int               // size of the extensions kept so far
+	chunks         int               // chunk lines read so far
+	bytesRemaining int64             // bytes left in current chunk
+	stickyErr      error             // persistent read error
 }
 
 func (r *chunkedBodyReader) Read(p []byte) (int, error) {
@@ -23,14 +64,16 @@ func (r *chunkedBodyReader) Read(p []byte) (int, error) {
 	if r.bytesRemaining == 0 {
 		size, err := r.nextChunkSize()
 		if err != nil {
-			r.stickyErr = err
-			return 0, err
+			r.stickyErr = noEOF(err)
+			return 0, r.stickyErr
 		}
 		r.bytesRemaining = size
 	}
 
-	// Last chunk (size 0) => EOF
+	// Last chunk (size 0) => EOF. Make it sticky so we never read past the
+	// body into the next request on the connection.
 	if r.bytesRemaining == 0 {
+		r.stickyErr = io.EOF
 		return 0, io.EOF
 	}
 
@@ -41,12 +84,13 @@ func (r *chunkedBodyReader) Read(p []byte) (int, error) {
 
 	n, err := r.reader.Read(p)
 	r.bytesRemaining -= int64(n)
+	err = noEOF(err)
 
 	// If chunk ended, consume trailing CRLF
 	if r.bytesRemaining == 0 && err == nil {
 		if err := r.consumeCRLF(); err != nil {
-			r.stickyErr = err
-			return n, err
+			r.stickyErr = noEOF(err)
+			return n, r.stickyErr
 		}
 	}
 
@@ -54,48 +98,125 @@ func (r *chunkedBodyReader) Read(p []byte) (int, error) {
 	return n, err
 }
 
+// noEOF turns io.EOF into io.ErrUnexpectedEOF. The body only ends with the
+// last chunk, a connection that ends before it is cut short.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
 func (r *chunkedBodyReader) nextChunkSize() (int64, error) {
-	line, err := r.readCRLFLine()
+	line, err := parser.ReadLineLimit(r.reader, maxChunkLineLength)
+	if err == parser.ErrLineTooLong {
+		return 0, errors.New("chunk line too long")
+	}
 	if err != nil {
 		return 0, err
 	}
 
-	size, err := strconv.ParseInt(strings.TrimSpace(line), 16, 64)
+	size, exts, err := parser.ParseChunkLine(line)
 	if err != nil {
 		return 0, err
 	}
+	if err := r.keepExtensions(exts); err != nil {
+		return 0, err
+	}
+	r.chunks++
 
 	// Final chunk → read trailers
 	if size == 0 {
-		for {
-			line, err := r.readCRLFLine()
-			if err != nil {
-				return 0, err
-			}
-			if line == "" {
-				break
-			}
+		if err := r.readTrailers(); err != nil {
+			return 0, err
 		}
 	}
 
 	return size, nil
 }
 
-func (r *chunkedBodyReader) readCRLFLine() (string, error) {
-	var line []byte
+// keepExtensions records the extensions of the current chunk.
+func (r *chunkedBodyReader) keepExtensions(exts []parser.ChunkExtension) error {
+	if r.exts == nil {
+		return nil
+	}
+	for _, ext := range exts {
+		r.extBytes += len(ext.Name) + len(ext.Value)
+		if r.extBytes > maxChunkExtensionBytes {
+			return errors.New("too many chunk extensions")
+		}
+		*r.exts = append(*r.exts, ChunkExtension{Chunk: r.chunks, Name: ext.Name, Value: ext.Value})
+	}
+	return nil
+}
 
+// readTrailers reads the trailer section after the last chunk into
+// req.Trailer. Fields that must never be sent as a trailer are dropped.
+func (r *chunkedBodyReader) readTrailers() error {
+	remain := maxTrailerBytes
 	for {
-		b, err := r.reader.ReadByte()
+		line, err := parser.ReadLineLimit(r.reader, max(remain-2, 0))
+		if err == parser.ErrLineTooLong {
+			return errors.New("trailer too large")
+		}
+		if err != nil {
+			return err
+		}
+		if len(line) == 0 {
+			return nil
+		}
+		remain -= len(line) + 2
+
+		k, v, err := parser.ParseHeaderField(line)
 		if err != nil {
-			return "", err
+			return fmt.Errorf("invalid trailer: %w", err)
 		}
-		if b == '\n' {
-			break
+		k = http.CanonicalHeaderKey(k)
+		if forbiddenTrailer(k) {
+			continue
+		}
+		if r.req.Trailer == nil {
+			r.req.Trailer = make(http.Header)
+		}
+		r.req.Trailer.Add(k, v)
+	}
+}
+
+// declareTrailers moves the fields announced in the Trailer header of a
+// chunked request to req.Trailer, as keys without values until the body has
+// been read, like in net/http. Announcing a framing field is an error.
+func declareTrailers(req *http.Request) error {
+	for _, v := range req.Header["Trailer"] {
+		for _, key := range strings.Split(v, ",") {
+			key = http.CanonicalHeaderKey(strings.Trim(key, " \t"))
+			switch {
+			case key == "Transfer-Encoding", key == "Trailer", key == "Content-Length":
+				return badRequest("invalid Trailer", fmt.Errorf("%s announced as trailer", key))
+			case key == "", forbiddenTrailer(key):
+				continue
+			}
+			if req.Trailer == nil {
+				req.Trailer = make(http.Header)
+			}
+			req.Trailer[key] = nil
 		}
-		line = append(line, b)
 	}
+	req.Header.Del("Trailer")
+	return nil
+}
 
-	return strings.TrimRight(string(line), "\r"), nil
+// forbiddenTrailer reports whether key is a field that is not allowed in a
+// trailer because it affects framing, routing or authentication.
+func forbiddenTrailer(key string) bool {
+	switch key {
+	case "Authorization", "Cache-Control", "Content-Encoding", "Content-Length",
+		"Content-Range", "Content-Type", "Expect", "Host", "Keep-Alive",
+		"Max-Forwards", "Pragma", "Proxy-Authenticate", "Proxy-Authorization",
+		"Proxy-Connection", "Range", "Realm", "Te", "Trailer",
+		"Transfer-Encoding", "Www-Authenticate":
+		return true
+	}
+	return false
 }
 
 func (r *chunkedBodyReader) consumeCRLF() error {
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestRequestTrailers(t *testing.T) {
+	_, addr := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Declared trailers are known up front, their values only after EOF.
+		before := r.Trailer.Get("X-Checksum")
+		_, declared := r.Trailer["X-Checksum"]
+		body, _ := io.ReadAll(r.Body)
+		io.WriteString(w, strings.Join([]string{
+			string(body),
+			before,
+			strconv.FormatBool(declared),
+			r.Trailer.Get("X-Checksum"),
+			r.Trailer.Get("X-Undeclared"),
+			r.Trailer.Get("Content-Length"),
+			r.Header.Get("Trailer"),
+		}, "|"))
+	}))
+
+	conn := dial(t, addr)
+	io.WriteString(conn, "POST / HTTP/1.1\r\nHost: a\r\nTrailer: X-Checksum\r\nTransfer-Encoding: chunked\r\n\r\n"+
+		"5\r\nhello\r\n0\r\nX-Checksum: abc123\r\nX-Undeclared:  also kept \r\nContent-Length: 99\r\n\r\n")
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if want := "hello||true|abc123|also kept||"; string(body) != want {
+		t.Errorf("got %q, want %q", body, want)
+	}
+}
+
+func TestChunkExtensions(t *testing.T) {
+	_, addr := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		fmt.Fprintf(w, "%s|%+v", body, ChunkExtensions(r))
+	}))
+
+	conn := dial(t, addr)
+	io.WriteString(conn, "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n"+
+		"5;sig=abc;last\r\nhello\r\n6\r\n world\r\n0;note=\"done; \\\"ok\\\"\"\r\n\r\n")
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	want := `hello world|[{Chunk:0 Name:sig Value:abc} {Chunk:0 Name:last Value:} {Chunk:2 Name:note Value:done; "ok"}]`
+	if string(body) != want {
+		t.Errorf("got %q, want %q", body, want)
+	}
+}
+
+func TestChunkedBodyLimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		wantErr string
+	}{
+		{"long chunk line", "5;x=" + strings.Repeat("a", maxChunkLineLength) + "\r\nhello\r\n0\r\n\r\n", "chunk line too long"},
+		{"size overflow", "8000000000000000\r\n", "chunk size overflows"},
+		{"too many digits", "00000000000000005\r\nhello\r\n0\r\n\r\n", "more than 16 hex digits"},
+		{"signed size", "+5\r\nhello\r\n0\r\n\r\n", "missing chunk size"},
+		{"space before extension", "5 ;x\r\nhello\r\n0\r\n\r\n", "expected ;"},
+		{"unterminated quote", "5;x=\"a\r\nhello\r\n0\r\n\r\n", "unterminated quoted-string"},
+		{"large trailer", "0\r\n" + strings.Repeat("X-Pad: "+strings.Repeat("a", 1000)+"\r\n", 70) + "\r\n", "trailer too large"},
+		{"extensions pile up", strings.Repeat("1;x="+strings.Repeat("a", 4000)+"\r\nz\r\n", 20) + "0\r\n\r\n", "too many chunk extensions"},
+		{"cut short", "5\r\nhello\r\n", "unexpected EOF"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &chunkedBodyReader{
+				reader: bufio.NewReader(strings.NewReader(tt.body)),
+				req:    new(http.Request),
+				exts:   new([]ChunkExtension),
+			}
+			_, err := io.ReadAll(r)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestChunkedBodyCutShort checks that a body that ends before the last
+// chunk is an error, not a shorter body. The fuzzer found the first case:
+// a chunked request with nothing after the headers.
+func TestChunkedBodyCutShort(t *testing.T) {
+	for _, body := range []string{
+		"",
+		"5\r\nhel",
+		"5\r\nhello",
+		"5\r\nhello\r\n",
+		"5\r\nhello\r\n0\r\n",
+		"5\r\nhello\r\n0\r\nX-Sum: 1\r\n",
+	} {
+		r := &chunkedBodyReader{reader: bufio.NewReader(strings.NewReader(body)), req: new(http.Request)}
+		if _, err := io.ReadAll(r); err != io.ErrUnexpectedEOF {
+			t.Errorf("%q: error = %v, want %v", body, err, io.ErrUnexpectedEOF)
+		}
+	}
+}
+
+// TestChunkedBodyStrict feeds chunk lines and trailers that net/http
+// rejects. The old line reader trimmed whitespace, took a sign and bare
+// LFs, and a negative size made Read panic.
+func TestChunkedBodyStrict(t *testing.T) {
+	for _, body := range []string{
+		"+5\r\nhello\r\n0\r\n\r\n",
+		"-1\r\nhello\r\n0\r\n\r\n",
+		" 5\r\nhello\r\n0\r\n\r\n",
+		"5 \r\nhello\r\n0\r\n\r\n",
+		"0x5\r\nhello\r\n0\r\n\r\n",
+		"00000000000000005\r\nhello\r\n0\r\n\r\n",
+		"5\nhello\r\n0\r\n\r\n",
+		"5\r\nhello\r\n0\r\nX-Sum: 1\n\r\n",
+		"5\r\nhello\r\n0\r\nX-Sum : 1\r\n\r\n",
+		"5\r\nhello\r\n0\r\nX-Sum: 1\r\n 2\r\n\r\n",
+	} {
+		r := &chunkedBodyReader{reader: bufio.NewReader(strings.NewReader(body)), req: new(http.Request)}
+		if _, err := io.ReadAll(r); err == nil || err == io.ErrUnexpectedEOF {
+			t.Errorf("%q: error = %v, want a syntax error", body, err)
+		}
+	}
+}
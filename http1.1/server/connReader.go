@@ -0,0 +1,155 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"sync"
+	"time"
+)
+
+// aLongTimeAgo is a read deadline in the past, it makes a blocked Read
+// return right away.
+var aLongTimeAgo = time.Unix(1, 0)
+
+// connReader reads from the connection for the request parser and caps how
+// much of it the request line and headers may take.
+//
+// Once the handler has the whole body it also keeps a read pending in the
+// background, so a client that goes away is noticed while the handler is
+// still running and the request's context gets cancelled. A byte that read
+// returns belongs to the next request and is handed out by the next Read.
+type connReader struct {
+	netConn net.Conn
+	remain  int64 // bytes left before the header limit
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	inRead  bool
+	aborted bool // set by abortPendingRead, the timeout that follows is ours
+	hasByte bool
+	byteBuf [1]byte
+
+	// cancelCtx cancels the context of the request being served. It is
+	// called when the connection fails or the client closes it.
+	cancelCtx context.CancelFunc
+}
+
+func newConnReader(netConn net.Conn, remain int64) *connReader {
+	cr := &connReader{netConn: netConn, remain: remain}
+	cr.cond = sync.NewCond(&cr.mu)
+	return cr
+}
+
+func (cr *connReader) setReadLimit(remain int64) { cr.remain = remain }
+func (cr *connReader) setInfiniteReadLimit()     { cr.remain = math.MaxInt64 }
+func (cr *connReader) hitReadLimit() bool        { return cr.remain <= 0 }
+
+// setCancelCtx sets the function called when the client goes away during
+// the current request.
+func (cr *connReader) setCancelCtx(cancel context.CancelFunc) {
+	cr.mu.Lock()
+	cr.cancelCtx = cancel
+	cr.mu.Unlock()
+}
+
+func (cr *connReader) Read(p []byte) (int, error) {
+	cr.mu.Lock()
+	if cr.inRead {
+		cr.mu.Unlock()
+		panic("server: concurrent read on connection")
+	}
+	if cr.hitReadLimit() {
+		cr.mu.Unlock()
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		cr.mu.Unlock()
+		return 0, nil
+	}
+	if int64(len(p)) > cr.remain {
+		p = p[:cr.remain]
+	}
+	if cr.hasByte {
+		p[0] = cr.byteBuf[0]
+		cr.hasByte = false
+		cr.remain--
+		cr.mu.Unlock()
+		return 1, nil
+	}
+	cr.inRead = true
+	cr.mu.Unlock()
+
+	n, err := cr.netConn.Read(p)
+
+	cr.mu.Lock()
+	cr.inRead = false
+	if err != nil {
+		cr.handleReadErrorLocked()
+	}
+	cr.remain -= int64(n)
+	cr.mu.Unlock()
+	cr.cond.Broadcast()
+	return n, err
+}
+
+// startBackgroundRead starts watching the connection while the handler
+// runs. It must only be called once the request body has been read, any
+// earlier and the watcher would eat the body.
+func (cr *connReader) startBackgroundRead() {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	if cr.inRead {
+		panic("server: background read with a read in progress")
+	}
+	if cr.hasByte {
+		return
+	}
+	cr.inRead = true
+	// The body is in, ReadTimeout no longer applies.
+	cr.netConn.SetReadDeadline(time.Time{})
+	go cr.backgroundRead()
+}
+
+func (cr *connReader) backgroundRead() {
+	n, err := cr.netConn.Read(cr.byteBuf[:])
+	cr.mu.Lock()
+	if n == 1 {
+		cr.hasByte = true
+	}
+	var ne net.Error
+	if errors.As(err, &ne) && cr.aborted && ne.Timeout() {
+		// Our own abortPendingRead, not a problem with the connection.
+	} else if err != nil {
+		cr.handleReadErrorLocked()
+	}
+	cr.aborted = false
+	cr.inRead = false
+	cr.mu.Unlock()
+	cr.cond.Broadcast()
+}
+
+// abortPendingRead stops the background read, if any, and waits for it to
+// return. It is called once the handler is done.
+func (cr *connReader) abortPendingRead() {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	if !cr.inRead {
+		return
+	}
+	cr.aborted = true
+	cr.netConn.SetReadDeadline(aLongTimeAgo)
+	for cr.inRead {
+		cr.cond.Wait()
+	}
+	cr.netConn.SetReadDeadline(time.Time{})
+}
+
+// handleReadErrorLocked tells the handler the client is gone.
+func (cr *connReader) handleReadErrorLocked() {
+	if cr.cancelCtx != nil {
+		cr.cancelCtx()
+	}
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestContextCanceledOnDisconnect(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		req  string
+	}{
+		{"no body", "GET / HTTP/1.1\r\nHost: a\r\n\r\n"},
+		{"content-length body", "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 5\r\n\r\nhello"},
+		{"chunked body", "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\n\r\n"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			canceled := make(chan error, 1)
+			_, addr := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				io.ReadAll(r.Body)
+				select {
+				case <-r.Context().Done():
+					canceled <- r.Context().Err()
+				case <-time.After(5 * time.Second):
+					canceled <- nil
+				}
+			}))
+
+			conn := dial(t, addr)
+			io.WriteString(conn, tt.req)
+			// Give the handler time to start before going away.
+			time.Sleep(50 * time.Millisecond)
+			conn.Close()
+
+			if err := <-canceled; err != context.Canceled {
+				t.Errorf("context error = %v, want %v", err, context.Canceled)
+			}
+		})
+	}
+}
+
+func TestContextNotCanceledByPipelinedRequest(t *testing.T) {
+	started := make(chan struct{}, 2)
+	_, addr := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		// A long-running handler, the next request shows up meanwhile.
+		time.Sleep(100 * time.Millisecond)
+		if err := r.Context().Err(); err != nil {
+			t.Errorf("%s: context error = %v while the client is still there", r.URL.Path, err)
+		}
+		io.WriteString(w, r.URL.Path)
+	}))
+
+	conn := dial(t, addr)
+	io.WriteString(conn, "GET /first HTTP/1.1\r\nHost: a\r\n\r\n")
+	<-started
+	// The background read picks up the start of this request, it must not
+	// get lost.
+	io.WriteString(conn, "GET /second HTTP/1.1\r\nHost: a\r\n\r\n")
+
+	br := bufio.NewReader(conn)
+	for _, want := range []string{"/first", "/second"} {
+		resp, err := http.ReadResponse(br, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		if string(body) != want {
+			t.Errorf("body = %q, want %q", body, want)
+		}
+	}
+}
+
+func TestContextNotCanceledBeforeBodyIsRead(t *testing.T) {
+	_, addr := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Watching the connection now would take the body away from us.
+		time.Sleep(50 * time.Millisecond)
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		if err := r.Context().Err(); err != nil {
+			t.Errorf("context error = %v", err)
+		}
+		w.Write(body)
+	}))
+
+	conn := dial(t, addr)
+	io.WriteString(conn, "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 5\r\n\r\n")
+	time.Sleep(20 * time.Millisecond)
+	io.WriteString(conn, "hello")
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := io.ReadAll(resp.Body); string(body) != "hello" {
+		t.Errorf("body = %q, want %q", body, "hello")
+	}
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestMalformedRequestResponses(t *testing.T) {
+	_, addr := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("handler called for %s %s", r.Method, r.RequestURI)
+	}))
+
+	tests := []struct {
+		name       string
+		request    string
+		wantStatus int
+		wantCheck  string
+	}{
+		{"no spaces", "GET\r\n\r\n", http.StatusBadRequest, "invalid method"},
+		{"unknown method", "BREW / HTTP/1.1\r\nHost: a\r\n\r\n", http.StatusNotImplemented, "invalid method"},
+		{"bad uri", "GET foo HTTP/1.1\r\nHost: a\r\n\r\n", http.StatusBadRequest, "invalid path"},
+		{"unsupported version", "GET / HTTP/2.0\r\nHost: a\r\n\r\n", http.StatusHTTPVersionNotSupported, "invalid protocol"},
+		{"garbage version", "GET / SPDY\r\nHost: a\r\n\r\n", http.StatusBadRequest, "invalid protocol"},
+		{"missing host", "GET / HTTP/1.1\r\n\r\n", http.StatusBadRequest, "missing Host header"},
+		{"bad header", "GET / HTTP/1.1\r\nHost: a\r\nnocolon\r\n\r\n", http.StatusBadRequest, "invalid header"},
+		{"double space", "GET  / HTTP/1.1\r\nHost: a\r\n\r\n", http.StatusBadRequest, "invalid path"},
+		{"lowercase version", "GET / http/1.1\r\nHost: a\r\n\r\n", http.StatusBadRequest, "invalid protocol"},
+		{"space before colon", "GET / HTTP/1.1\r\nHost : a\r\n\r\n", http.StatusBadRequest, "invalid header"},
+		{"obs-fold", "GET / HTTP/1.1\r\nHost: a\r\nX-A: b\r\n c\r\n\r\n", http.StatusBadRequest, "invalid header"},
+		{"control in value", "GET / HTTP/1.1\r\nHost: a\r\nX-A: b\x00c\r\n\r\n", http.StatusBadRequest, "invalid header"},
+		{"bare LF", "GET / HTTP/1.1\nHost: a\r\n\r\n", http.StatusBadRequest, "invalid line"},
+		{"bare CR", "GET / HTTP/1.1\r\nHost: a\rX-A: b\r\n\r\n", http.StatusBadRequest, "invalid line"},
+		{"bad content-length", "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: five\r\n\r\n", http.StatusBadRequest, "invalid Content-Length"},
+		{"negative content-length", "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: -1\r\n\r\n", http.StatusBadRequest, "invalid Content-Length"},
+		{"huge headers", "GET / HTTP/1.1\r\nHost: a\r\nX-Big: " + strings.Repeat("a", DefaultMaxHeaderBytes) + "\r\n\r\n", http.StatusRequestHeaderFieldsTooLarge, "headers too large"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			conn := dial(t, addr)
+			go io.WriteString(conn, tt.request)
+
+			resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if !strings.HasSuffix(string(body), tt.wantCheck) {
+				t.Errorf("body = %q, want it to name %q", body, tt.wantCheck)
+			}
+			if !resp.Close {
+				t.Error("connection not closed after error response")
+			}
+		})
+	}
+}
@@ -1,12 +1,16 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
 	"strconv"
+	"time"
 
 	"github.com/kianooshaz/http-from-scratch/http1.1/server"
 )
@@ -42,12 +46,32 @@ func main() {
 		json.NewEncoder(w).Encode(r.Header)
 	})
 	mux.HandleFunc("/nothing", func(w http.ResponseWriter, r *http.Request) {})
-	s := server.Server{
+	s := &server.Server{
 		Addr:    addr,
 		Handler: mux,
 	}
-	log.Printf("Starting web server: http://%s", addr)
-	if err := s.ListenAndServe(); err != nil {
-		log.Fatal(err)
+
+	go func() {
+		log.Printf("Starting web server: http://%s", addr)
+		if err := s.ListenAndServe(); err != nil && err != server.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	gracefulShutdown(s)
+}
+
+func gracefulShutdown(s *server.Server) {
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt)
+
+	<-quit
+	log.Println("shutting down...")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := s.Shutdown(ctx); err != nil {
+		log.Println("shutdown error:", err)
 	}
 }
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/kianooshaz/http-from-scratch/internal/parser"
+)
+
+// framing is how the length of a request body is determined.
+type framing struct {
+	chunked       bool
+	contentLength int64
+	// ambiguous framing is accepted, but the connection is closed after
+	// the response rather than trusting where we think the next request
+	// starts.
+	ambiguous bool
+}
+
+// setFraming works out the framing of req and, like net/http, moves it out
+// of the header into the request fields.
+func setFraming(req *http.Request) (framing, error) {
+	f, err := requestFraming(req)
+	if err != nil {
+		return f, err
+	}
+	req.ContentLength = f.contentLength
+	if f.chunked {
+		req.TransferEncoding = []string{"chunked"}
+		req.Header.Del("Transfer-Encoding")
+		if err := declareTrailers(req); err != nil {
+			return f, err
+		}
+	} else if f.ambiguous {
+		req.Header.Set("Content-Length", strconv.FormatInt(req.ContentLength, 10))
+	}
+	return f, nil
+}
+
+// requestFraming applies the message body length rules of RFC 9112 section
+// 6.3 to a request. Everything a proxy in front of us might read
+// differently is rejected, since that difference is what request smuggling
+// is made of:
+//
+//   - Content-Length together with Transfer-Encoding
+//   - Transfer-Encoding in an HTTP/1.0 request
+//   - a Transfer-Encoding whose final coding isn't chunked, or with chunked
+//     more than once
+//   - Content-Length values that differ or aren't plain digits
+//
+// Identical repeated Content-Length values are folded into one, like in
+// net/http, and count as ambiguous.
+func requestFraming(req *http.Request) (framing, error) {
+	te, haveTE := req.Header["Transfer-Encoding"]
+	cl, haveCL := req.Header["Content-Length"]
+
+	if haveTE {
+		if haveCL {
+			return framing{}, badRequest("invalid framing", errors.New("both Content-Length and Transfer-Encoding"))
+		}
+		if !req.ProtoAtLeast(1, 1) {
+			return framing{}, badRequest("invalid Transfer-Encoding", errors.New("Transfer-Encoding in an HTTP/1.0 request"))
+		}
+		if err := checkTransferEncoding(te); err != nil {
+			return framing{}, err
+		}
+		return framing{chunked: true, contentLength: -1}, nil
+	}
+
+	if !haveCL {
+		return framing{}, nil
+	}
+	n, ambiguous, err := parser.ParseContentLength(cl)
+	if err != nil {
+		return framing{}, badRequest("invalid Content-Length", err)
+	}
+	return framing{contentLength: n, ambiguous: ambiguous}, nil
+}
+
+// checkTransferEncoding accepts exactly one coding, chunked. Other codings
+// are well formed but not something we decode.
+func checkTransferEncoding(values []string) error {
+	var codings []string
+	for _, v := range values {
+		for _, coding := range strings.Split(v, ",") {
+			coding = strings.Trim(coding, " \t")
+			if !parser.ValidToken(coding) {
+				return badRequest("invalid Transfer-Encoding", fmt.Errorf("invalid coding %q", coding))
+			}
+			codings = append(codings, coding)
+		}
+	}
+
+	last := len(codings) - 1
+	if !strings.EqualFold(codings[last], "chunked") {
+		return badRequest("invalid Transfer-Encoding", errors.New("chunked is not the final coding"))
+	}
+	for _, coding := range codings[:last] {
+		if strings.EqualFold(coding, "chunked") {
+			return badRequest("invalid Transfer-Encoding", errors.New("chunked applied more than once"))
+		}
+	}
+	if len(codings) > 1 {
+		return &RequestError{StatusCode: http.StatusNotImplemented, Check: "unsupported Transfer-Encoding", Err: fmt.Errorf("codings %q", codings)}
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/kianooshaz/http-from-scratch/internal/servertest"
+)
+
+// TestRequestSmuggling sends known smuggling payloads. Each one hides a
+// request for /smuggled that a server disagreeing with a proxy in front of
+// it about where the first request ends would serve. We must never serve
+// it: the request is either rejected, or the connection closed after it.
+func TestRequestSmuggling(t *testing.T) {
+	const smuggled = "GET /smuggled HTTP/1.1\r\nHost: a\r\n\r\n"
+	tests := []struct {
+		name       string
+		request    string
+		wantStatus int
+		wantCheck  string // for rejected requests
+	}{
+		// CL.TE: the front end goes by Content-Length, we would go by
+		// Transfer-Encoding.
+		{
+			name: "CL.TE",
+			request: "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: " + strconv.Itoa(len("0\r\n\r\n"+smuggled)) + "\r\n" +
+				"Transfer-Encoding: chunked\r\n\r\n0\r\n\r\n" + smuggled,
+			wantStatus: http.StatusBadRequest, wantCheck: "invalid framing",
+		},
+		// TE.CL: the other way around.
+		{
+			name: "TE.CL",
+			request: "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 4\r\nTransfer-Encoding: chunked\r\n\r\n" +
+				strconv.FormatInt(int64(len(smuggled)), 16) + "\r\n" + smuggled + "\r\n0\r\n\r\n",
+			wantStatus: http.StatusBadRequest, wantCheck: "invalid framing",
+		},
+		{
+			name: "TE.CL with Content-Length first",
+			request: "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\nContent-Length: 4\r\n\r\n" +
+				strconv.FormatInt(int64(len(smuggled)), 16) + "\r\n" + smuggled + "\r\n0\r\n\r\n",
+			wantStatus: http.StatusBadRequest, wantCheck: "invalid framing",
+		},
+
+		// TE.TE: one of the two servers is tricked into not seeing chunked.
+		{
+			name:       "TE.TE coding not final",
+			request:    "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\nTransfer-Encoding: identity\r\n\r\n0\r\n\r\n" + smuggled,
+			wantStatus: http.StatusBadRequest, wantCheck: "invalid Transfer-Encoding",
+		},
+		{
+			name:       "TE.TE coding list not final",
+			request:    "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked, identity\r\n\r\n0\r\n\r\n" + smuggled,
+			wantStatus: http.StatusBadRequest, wantCheck: "invalid Transfer-Encoding",
+		},
+		{
+			name:       "TE.TE chunked twice",
+			request:    "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked, chunked\r\n\r\n0\r\n\r\n" + smuggled,
+			wantStatus: http.StatusBadRequest, wantCheck: "invalid Transfer-Encoding",
+		},
+		{
+			name:       "TE.TE unknown coding",
+			request:    "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: xchunked\r\n\r\n0\r\n\r\n" + smuggled,
+			wantStatus: http.StatusBadRequest, wantCheck: "invalid Transfer-Encoding",
+		},
+		{
+			name:       "TE.TE quoted coding",
+			request:    "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: \"chunked\"\r\n\r\n0\r\n\r\n" + smuggled,
+			wantStatus: http.StatusBadRequest, wantCheck: "invalid Transfer-Encoding",
+		},
+		{
+			name:       "TE.TE empty",
+			request:    "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding:\r\n\r\n0\r\n\r\n" + smuggled,
+			wantStatus: http.StatusBadRequest, wantCheck: "invalid Transfer-Encoding",
+		},
+		{
+			name:       "TE.TE space before colon",
+			request:    "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding : chunked\r\n\r\n0\r\n\r\n" + smuggled,
+			wantStatus: http.StatusBadRequest, wantCheck: "invalid header",
+		},
+		{
+			name:       "TE.TE vertical tab",
+			request:    "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding:\x0bchunked\r\n\r\n0\r\n\r\n" + smuggled,
+			wantStatus: http.StatusBadRequest, wantCheck: "invalid header",
+		},
+		{
+			name:       "TE.TE obs-fold",
+			request:    "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding:\r\n chunked\r\n\r\n0\r\n\r\n" + smuggled,
+			wantStatus: http.StatusBadRequest, wantCheck: "invalid header",
+		},
+		{
+			name:       "TE.TE bare LF",
+			request:    "POST / HTTP/1.1\r\nHost: a\r\nX-A: b\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n" + smuggled,
+			wantStatus: http.StatusBadRequest, wantCheck: "invalid line",
+		},
+		{
+			name:       "TE.TE in HTTP/1.0",
+			request:    "POST / HTTP/1.0\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n" + smuggled,
+			wantStatus: http.StatusBadRequest, wantCheck: "invalid Transfer-Encoding",
+		},
+		{
+			name:       "unsupported coding",
+			request:    "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: gzip, chunked\r\n\r\n0\r\n\r\n" + smuggled,
+			wantStatus: http.StatusNotImplemented, wantCheck: "unsupported Transfer-Encoding",
+		},
+		{
+			name:       "framing field announced as trailer",
+			request:    "POST / HTTP/1.1\r\nHost: a\r\nTrailer: Content-Length\r\nTransfer-Encoding: chunked\r\n\r\n0\r\nContent-Length: 35\r\n\r\n" + smuggled,
+			wantStatus: http.StatusBadRequest, wantCheck: "invalid Trailer",
+		},
+
+		// CL.CL: two lengths to pick from.
+		{
+			name:       "CL.CL conflicting lines",
+			request:    "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 0\r\nContent-Length: " + strconv.Itoa(len(smuggled)) + "\r\n\r\n" + smuggled,
+			wantStatus: http.StatusBadRequest, wantCheck: "invalid Content-Length",
+		},
+		{
+			name:       "CL.CL conflicting list",
+			request:    "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 0, " + strconv.Itoa(len(smuggled)) + "\r\n\r\n" + smuggled,
+			wantStatus: http.StatusBadRequest, wantCheck: "invalid Content-Length",
+		},
+		{
+			name:       "CL with sign",
+			request:    "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: +0\r\n\r\n" + smuggled,
+			wantStatus: http.StatusBadRequest, wantCheck: "invalid Content-Length",
+		},
+		{
+			name:       "CL in hex",
+			request:    "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 0x0\r\n\r\n" + smuggled,
+			wantStatus: http.StatusBadRequest, wantCheck: "invalid Content-Length",
+		},
+		{
+			name:       "CL empty",
+			request:    "POST / HTTP/1.1\r\nHost: a\r\nContent-Length:\r\n\r\n" + smuggled,
+			wantStatus: http.StatusBadRequest, wantCheck: "invalid Content-Length",
+		},
+		{
+			name:       "CL overflow",
+			request:    "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 18446744073709551616\r\n\r\n" + smuggled,
+			wantStatus: http.StatusBadRequest, wantCheck: "invalid Content-Length",
+		},
+
+		// Equal lengths are allowed, but we don't trust the connection
+		// afterwards.
+		{
+			name:       "CL.CL equal lines",
+			request:    "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 5\r\nContent-Length: 5\r\n\r\nhello" + smuggled,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "CL.CL equal list",
+			request:    "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 5, 5\r\n\r\nhello" + smuggled,
+			wantStatus: http.StatusOK,
+		},
+	}
+
+	_, addr := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		if r.URL.Path == "/smuggled" {
+			t.Error("smuggled request was served")
+		}
+	}))
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			conn := dial(t, addr)
+			go io.WriteString(conn, tt.request)
+
+			br := bufio.NewReader(conn)
+			resp, err := http.ReadResponse(br, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if tt.wantCheck != "" && !strings.HasSuffix(string(body), tt.wantCheck) {
+				t.Errorf("body = %q, want it to name %q", body, tt.wantCheck)
+			}
+			if !resp.Close {
+				t.Error("connection kept alive")
+			}
+			if rest, _ := io.ReadAll(br); len(rest) > 0 {
+				t.Errorf("more after the first response: %q", rest)
+			}
+		})
+	}
+
+}
+
+func TestContentLengthNormalized(t *testing.T) {
+	got := make(chan servertest.Request, 1)
+	_, addr := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got <- servertest.Snapshot(r)
+	}))
+
+	conn := dial(t, addr)
+	io.WriteString(conn, "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 5\r\nContent-Length: 5, 5\r\n\r\nhello")
+	if _, err := http.ReadResponse(bufio.NewReader(conn), nil); err != nil {
+		t.Fatal(err)
+	}
+	r := <-got
+	if r.ContentLength != 5 || strings.Join(r.Header["Content-Length"], "|") != "5" || r.Body != "hello" {
+		t.Errorf("ContentLength %d, header %q, body %q, want 5, a single %q, %q",
+			r.ContentLength, r.Header["Content-Length"], r.Body, "5", "hello")
+	}
+}
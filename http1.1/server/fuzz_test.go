@@ -0,0 +1,153 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+// readerConn is a connection that only reads, from r.
+type readerConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c readerConn) Read(p []byte) (int, error) { return c.r.Read(p) }
+
+// checkAllocs fails if f allocates much more than the input could explain.
+func checkAllocs(t *testing.T, input []byte, f func()) {
+	t.Helper()
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	f()
+	runtime.ReadMemStats(&after)
+	if n, limit := after.TotalAlloc-before.TotalAlloc, uint64(64*len(input)+64<<10); n > limit {
+		t.Errorf("allocated %d bytes for %d bytes of input, want at most %d", n, len(input), limit)
+	}
+}
+
+// parsedRequest is what a parser made of a request.
+type parsedRequest struct {
+	Method string
+	URL    string
+	Host   string
+	Header http.Header
+	Body   string
+}
+
+// readFirstRequest parses the first request in data the way handleRequest
+// does. ok is false if it was rejected or its body is incomplete.
+func readFirstRequest(data []byte) (pr parsedRequest, ambiguous bool, ok bool) {
+	c := (&Server{}).newConn(readerConn{r: bytes.NewReader(data)})
+	req, err := readRequest(c)
+	if err != nil {
+		return pr, false, false
+	}
+	c.r.setInfiniteReadLimit()
+	framing, err := setFraming(req)
+	if err != nil {
+		return pr, false, false
+	}
+	var body io.Reader = &bodyReader{reader: io.LimitReader(c.bufr, req.ContentLength), n: req.ContentLength}
+	if framing.chunked {
+		body = &chunkedBodyReader{reader: c.bufr, req: req}
+	}
+	b, err := io.ReadAll(body)
+	if err != nil {
+		return pr, false, false
+	}
+	return parsedRequest{req.Method, req.URL.String(), req.Host, req.Header, string(b)}, framing.ambiguous, true
+}
+
+var fuzzSeeds = []string{
+	"GET / HTTP/1.1\r\nHost: a\r\n\r\n",
+	"GET http://a/b?c=d HTTP/1.1\r\nHost: b\r\nAccept: */*\r\nAccept: text/html\r\n\r\n",
+	"GET / HTTP/1.0\r\nConnection: keep-alive\r\nPragma: no-cache\r\n\r\n",
+	"OPTIONS * HTTP/1.1\r\nHost: a\r\n\r\n",
+	"CONNECT a:443 HTTP/1.1\r\nHost: a:443\r\n\r\n",
+	"POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 5\r\n\r\nhello",
+	"POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 5\r\nContent-Length: 5\r\n\r\nhello",
+	"POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\n\r\n",
+	"POST / HTTP/1.1\r\nHost: a\r\nTrailer: X-Sum\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\nX-Sum: 1\r\n\r\n",
+	"POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 4\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n",
+	"GET / HTTP/1.1\r\nHost: a\r\nX-A: b\r\n c\r\n\r\n",
+	"GET / HTTP/1.1\nHost: a\n\n",
+}
+
+// FuzzReadRequest feeds arbitrary bytes to the request parser. Whatever it
+// accepts, net/http must read the same way.
+func FuzzReadRequest(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var got parsedRequest
+		var ambiguous, ok bool
+		checkAllocs(t, data, func() {
+			got, ambiguous, ok = readFirstRequest(data)
+		})
+		// Content-Length lists are allowed by RFC 9112 but not by net/http.
+		if !ok || ambiguous {
+			return
+		}
+
+		req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(data)))
+		if err != nil {
+			t.Fatalf("accepted %q, net/http rejects it: %v", data, err)
+		}
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			t.Fatalf("accepted %q, net/http fails reading the body: %v", data, err)
+		}
+		want := parsedRequest{req.Method, req.URL.String(), req.Host, req.Header, string(body)}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("request %q\n got %+v\nwant %+v", data, got, want)
+		}
+	})
+}
+
+// FuzzChunkedBodyReader decodes arbitrary bytes as a chunked body. Whatever
+// it accepts, net/http must decode to the same bytes.
+func FuzzChunkedBodyReader(f *testing.F) {
+	for _, seed := range []string{
+		"5\r\nhello\r\n0\r\n\r\n",
+		"3\r\nwor\r\n2\r\nld\r\n0\r\n\r\n",
+		"A\r\n0123456789\r\n0\r\nX-Sum: 1\r\n\r\n",
+		"0\r\n\r\n",
+		"5;ext=1\r\nhello\r\n0\r\n\r\n",
+		"5\nhello\n0\n\n",
+		"-1\r\n\r\n",
+		"ffffffffffffffff\r\n",
+	} {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var got []byte
+		var err error
+		checkAllocs(t, data, func() {
+			r := &chunkedBodyReader{reader: bufio.NewReader(bytes.NewReader(data)), req: new(http.Request)}
+			got, err = io.ReadAll(r)
+		})
+		if err != nil {
+			return
+		}
+
+		head := "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n"
+		req, err := http.ReadRequest(bufio.NewReader(io.MultiReader(bytes.NewReader([]byte(head)), bytes.NewReader(data))))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := io.ReadAll(req.Body)
+		if err != nil {
+			t.Fatalf("decoded %q, net/http fails: %v", data, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("decoded %q to %q, net/http to %q", data, got, want)
+		}
+	})
+}
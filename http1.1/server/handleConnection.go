@@ -1,25 +1,147 @@
 package server
 
 import (
+	"bufio"
+	"crypto/tls"
 	"errors"
+	"fmt"
 	"io"
 	"net"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
 )
 
+// conn holds the per-connection state that outlives a single request.
+type conn struct {
+	server  *Server
+	netConn net.Conn
+
+	// r sits between netConn and bufr. It caps how much is read for the
+	// headers of each request and watches for the client going away while
+	// a handler runs.
+	r *connReader
+
+	// bufr is shared by every request on the connection. A client may
+	// pipeline several requests in one write, so bytes of the next request
+	// can already be buffered when the current one is done.
+	bufr *bufio.Reader
+
+	// bufw collects a response so the status line, headers and small
+	// chunks leave in a few writes rather than one syscall per token. The
+	// response writer flushes it when the response is done or on Flush.
+	bufw *bufio.Writer
+
+	// tlsState is the negotiated TLS state, or nil for plain connections.
+	tlsState *tls.ConnectionState
+
+	// hijacked is set once a handler has taken over the connection. It is
+	// no longer ours to read, write or close.
+	hijacked atomic.Bool
+}
+
+func (s *Server) newConn(netConn net.Conn) *conn {
+	cr := newConnReader(netConn, int64(s.maxHeaderBytes()))
+	return &conn{
+		server:  s,
+		netConn: netConn,
+		r:       cr,
+		bufr:    bufio.NewReader(cr),
+		bufw:    bufio.NewWriter(netConn),
+	}
+}
+
 func (s *Server) handleConnection(conn net.Conn) error {
-	defer conn.Close()
+	c := s.newConn(conn)
+	defer func() {
+		if !c.hijacked.Load() {
+			conn.Close()
+		}
+	}()
+	if !s.trackConn(conn, true) {
+		return nil // Server is shutting down, don't start serving.
+	}
+	defer s.trackConn(conn, false)
+
+	// A new connection gets ReadHeaderTimeout to send its first request.
+	if d := s.readHeaderTimeout(); d > 0 {
+		conn.SetReadDeadline(time.Now().Add(d))
+	}
+
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		// Handshake now rather than on the first read, so the connection
+		// state is known before any request is handed to the Handler.
+		if d := s.WriteTimeout; d > 0 {
+			conn.SetWriteDeadline(time.Now().Add(d))
+		}
+		if err := tlsConn.Handshake(); err != nil {
+			return fmt.Errorf("TLS handshake error from %s: %w", conn.RemoteAddr(), err)
+		}
+		state := tlsConn.ConnectionState()
+		c.tlsState = &state
+	}
+
 	for {
 		// handleRequest does the work of reading and responding
-		shouldClose, err := s.handleRequest(conn)
+		shouldClose, err := s.handleRequest(c)
 		if err != nil {
 			// io.EOF is a normal way for a persistent connection to end.
 			if errors.Is(err, io.EOF) {
 				return nil
 			}
+			// So is a client that is too slow or has gone quiet.
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				return nil
+			}
+			// Shutdown closes idle connections under our feet, that's not an error.
+			if s.shuttingDown() && errors.Is(err, net.ErrClosed) {
+				return nil
+			}
 			return err
 		}
 		if shouldClose {
 			return nil // Client requested a close, so we exit the loop.
 		}
+		if c.hijacked.Load() {
+			return nil // The handler owns the connection now.
+		}
+
+		// Mark the connection idle before checking for shutdown so that
+		// Shutdown either sees it idle and closes it, or we see the flag.
+		s.setConnState(conn, stateIdle)
+		if s.shuttingDown() {
+			return nil
+		}
+
+		// Wait at most IdleTimeout for the next request to start.
+		if d := s.idleTimeout(); d > 0 {
+			conn.SetReadDeadline(time.Now().Add(d))
+		} else {
+			conn.SetReadDeadline(time.Time{})
+		}
+	}
+}
+
+// hijack hands the connection over to a handler. Bytes of the connection
+// we have already read are in the returned reader, so nothing is lost.
+func (c *conn) hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if c.hijacked.Load() {
+		return nil, nil, http.ErrHijacked
 	}
+	c.hijacked.Store(true)
+
+	// Stop watching for the client going away. A byte the watcher already
+	// got goes into bufr with the rest.
+	c.r.abortPendingRead()
+	c.r.setCancelCtx(nil)
+	if c.r.hasByte {
+		if _, err := c.bufr.Peek(c.bufr.Buffered() + 1); err != nil {
+			return nil, nil, fmt.Errorf("reading buffered byte: %w", err)
+		}
+	}
+
+	// Shutdown and Close leave hijacked connections alone.
+	c.server.trackConn(c.netConn, false)
+	return c.netConn, bufio.NewReadWriter(c.bufr, c.bufw), nil
 }
@@ -0,0 +1,334 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPipelinedRequests(t *testing.T) {
+	_, addr := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		io.WriteString(w, r.Method+" "+r.URL.Path+" "+string(body))
+	}))
+
+	tests := []struct {
+		name     string
+		requests string
+		want     []string
+	}{
+		{
+			name: "gets",
+			requests: "GET /one HTTP/1.1\r\nHost: example.com\r\n\r\n" +
+				"GET /two HTTP/1.1\r\nHost: example.com\r\n\r\n" +
+				"GET /three HTTP/1.1\r\nHost: example.com\r\n\r\n",
+			want: []string{"GET /one ", "GET /two ", "GET /three "},
+		},
+		{
+			name: "content-length bodies",
+			requests: "POST /one HTTP/1.1\r\nHost: example.com\r\nContent-Length: 5\r\n\r\nhello" +
+				"POST /two HTTP/1.1\r\nHost: example.com\r\nContent-Length: 5\r\n\r\nworld" +
+				"GET /three HTTP/1.1\r\nHost: example.com\r\n\r\n",
+			want: []string{"POST /one hello", "POST /two world", "GET /three "},
+		},
+		{
+			name: "chunked bodies",
+			requests: "POST /one HTTP/1.1\r\nHost: example.com\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\n\r\n" +
+				"POST /two HTTP/1.1\r\nHost: example.com\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nwor\r\n2\r\nld\r\n0\r\n\r\n" +
+				"GET /three HTTP/1.1\r\nHost: example.com\r\n\r\n",
+			want: []string{"POST /one hello", "POST /two world", "GET /three "},
+		},
+		{
+			name: "mixed",
+			requests: "GET /one HTTP/1.1\r\nHost: example.com\r\n\r\n" +
+				"PUT /two HTTP/1.1\r\nHost: example.com\r\nContent-Length: 3\r\n\r\nabc" +
+				"GET /three HTTP/1.1\r\nHost: example.com\r\nConnection: close\r\n\r\n",
+			want: []string{"GET /one ", "PUT /two abc", "GET /three "},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := dial(t, addr)
+			// Everything goes out in a single write.
+			if _, err := io.WriteString(conn, tt.requests); err != nil {
+				t.Fatal(err)
+			}
+
+			br := bufio.NewReader(conn)
+			for i, want := range tt.want {
+				resp, err := http.ReadResponse(br, nil)
+				if err != nil {
+					t.Fatalf("response %d: %v", i, err)
+				}
+				body, err := io.ReadAll(resp.Body)
+				if err != nil {
+					t.Fatalf("response %d body: %v", i, err)
+				}
+				if got := string(body); got != want {
+					t.Errorf("response %d = %q, want %q", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPipelinedRequestsUnreadBody(t *testing.T) {
+	// The handler never reads the body, the server must skip it to find the
+	// next request.
+	_, addr := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.URL.Path)
+	}))
+
+	conn := dial(t, addr)
+	requests := "POST /one HTTP/1.1\r\nHost: example.com\r\nContent-Length: 11\r\n\r\nGET /evil\r\n" +
+		"POST /two HTTP/1.1\r\nHost: example.com\r\nTransfer-Encoding: chunked\r\n\r\n4\r\nGET \r\n0\r\n\r\n" +
+		"GET /three HTTP/1.1\r\nHost: example.com\r\n\r\n"
+	if _, err := io.WriteString(conn, requests); err != nil {
+		t.Fatal(err)
+	}
+
+	br := bufio.NewReader(conn)
+	for i, want := range []string{"/one", "/two", "/three"} {
+		resp, err := http.ReadResponse(br, nil)
+		if err != nil {
+			t.Fatalf("response %d: %v", i, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		if got := strings.TrimSpace(string(body)); got != want {
+			t.Errorf("response %d = %q, want %q", i, got, want)
+		}
+	}
+}
+
+// TestUnreadBodyNotDrained checks that a large body the handler ignores
+// isn't read to the end just to keep the connection.
+func TestUnreadBodyNotDrained(t *testing.T) {
+	var calls atomic.Int32
+	_, addr := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		io.WriteString(w, "ignored")
+	}))
+
+	const size = 64 << 20
+	conn := dial(t, addr)
+	writeErr := make(chan error, 1)
+	go func() {
+		_, err := io.WriteString(conn, "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: "+strconv.Itoa(size)+"\r\n\r\n")
+		if err == nil {
+			_, err = conn.Write(make([]byte, size))
+		}
+		writeErr <- err
+	}()
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "ignored" {
+		t.Errorf("body = %q, want %q", body, "ignored")
+	}
+	if _, err := br.ReadByte(); errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Error("connection kept open")
+	}
+	if err := <-writeErr; err == nil {
+		t.Error("server read the whole body")
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("handler called %d times, want 1", n)
+	}
+}
+
+func TestHijack(t *testing.T) {
+	hijacked := make(chan struct{})
+	s, addr := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Connection", "Upgrade")
+		w.Header().Set("Upgrade", "echo")
+		w.WriteHeader(http.StatusSwitchingProtocols)
+
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		if _, _, err := w.(http.Hijacker).Hijack(); err != http.ErrHijacked {
+			t.Errorf("second Hijack error = %v, want %v", err, http.ErrHijacked)
+		}
+		if _, err := w.Write([]byte("x")); err != http.ErrHijacked {
+			t.Errorf("Write after Hijack error = %v, want %v", err, http.ErrHijacked)
+		}
+		close(hijacked)
+
+		// Echo lines until the client is done. The first one was sent
+		// along with the request, so it is already buffered.
+		for {
+			line, err := rw.ReadString('\n')
+			if err != nil {
+				return
+			}
+			rw.WriteString("echo: " + line)
+			rw.Flush()
+		}
+	}))
+
+	conn := dial(t, addr)
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: a\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\nfirst\n")
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusSwitchingProtocols)
+	}
+	if got := resp.Header.Get("Connection"); got != "Upgrade" {
+		t.Errorf("Connection = %q, want %q", got, "Upgrade")
+	}
+
+	<-hijacked
+	// The server lets go of hijacked connections.
+	s.Close()
+
+	io.WriteString(conn, "second\n")
+	for _, want := range []string{"echo: first\n", "echo: second\n"} {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line != want {
+			t.Errorf("got %q, want %q", line, want)
+		}
+	}
+}
+
+// TestReadHeaderTimeout plays a slowloris client, sending a header line
+// every so often but never finishing the request.
+func TestReadHeaderTimeout(t *testing.T) {
+	addr := startTestServer(t, &Server{
+		ReadHeaderTimeout: 100 * time.Millisecond,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			t.Error("handler called for an unfinished request")
+		}),
+	})
+
+	conn := dial(t, addr)
+	start := time.Now()
+	go func() {
+		if _, err := io.WriteString(conn, "GET / HTTP/1.1\r\nHost: a\r\n"); err != nil {
+			return
+		}
+		for {
+			time.Sleep(20 * time.Millisecond)
+			if _, err := io.WriteString(conn, "X-Slow: 1\r\n"); err != nil {
+				return
+			}
+		}
+	}()
+
+	// Closed, or reset if our last header line was still unread.
+	if _, err := io.ReadAll(conn); errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatal("connection not closed by the server")
+	}
+	if d := time.Since(start); d < 100*time.Millisecond || d > time.Second {
+		t.Errorf("connection closed after %v, want about 100ms", d)
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	addr := startTestServer(t, &Server{
+		IdleTimeout: 100 * time.Millisecond,
+		Handler:     http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+	})
+
+	conn := dial(t, addr)
+	br := bufio.NewReader(conn)
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: a\r\n\r\n")
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.ReadAll(resp.Body)
+	if resp.Close {
+		t.Fatal("connection not kept alive")
+	}
+
+	start := time.Now()
+	if _, err := br.ReadByte(); err != io.EOF {
+		t.Fatalf("reading the idle connection = %v, want %v", err, io.EOF)
+	}
+	if d := time.Since(start); d < 90*time.Millisecond || d > time.Second {
+		t.Errorf("idle connection closed after %v, want about 100ms", d)
+	}
+}
+
+// TestWriteTimeout checks that writing to a client that stopped reading
+// fails instead of blocking the handler forever.
+func TestWriteTimeout(t *testing.T) {
+	errc := make(chan error, 1)
+	addr := startTestServer(t, &Server{
+		WriteTimeout: 100 * time.Millisecond,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			chunk := make([]byte, 1<<20)
+			for {
+				if _, err := w.Write(chunk); err != nil {
+					errc <- err
+					return
+				}
+			}
+		}),
+	})
+
+	conn := dial(t, addr)
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: a\r\n\r\n")
+	select {
+	case err := <-errc:
+		if !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Errorf("Write = %v, want %v", err, os.ErrDeadlineExceeded)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("write to a stalled client did not time out")
+	}
+}
+
+// TestWriteTimeoutRejectedRequest checks that a request rejected on a
+// kept-alive connection is answered under its own WriteTimeout, not what
+// is left of the previous one.
+func TestWriteTimeoutRejectedRequest(t *testing.T) {
+	addr := startTestServer(t, &Server{
+		WriteTimeout: 200 * time.Millisecond,
+		Handler:      http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+	})
+
+	conn := dial(t, addr)
+	br := bufio.NewReader(conn)
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: a\r\n\r\n")
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.ReadAll(resp.Body)
+
+	time.Sleep(400 * time.Millisecond)
+	io.WriteString(conn, "GET / HTTP/1.1\r\n\r\n")
+	resp, err = http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+}
@@ -1,132 +1,343 @@
 package server
 
 import (
-	"bufio"
-	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"io"
-	"math"
-	"net"
 	"net/http"
 	"net/url"
-	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/kianooshaz/http-from-scratch/internal/httperr"
+	"github.com/kianooshaz/http-from-scratch/internal/parser"
 )
 
-func (s *Server) handleRequest(conn net.Conn) (bool, error) {
-	// Limit headers to 1MB
-	limitReader := io.LimitReader(conn, 1*1024*1024).(*io.LimitedReader)
-	reader := bufio.NewReader(limitReader)
+func (s *Server) handleRequest(c *conn) (bool, error) {
+	conn := c.netConn
+	reader := c.bufr
 
-	reqLineBytes, _, err := reader.ReadLine()
-	if err != nil {
+	c.r.setReadLimit(int64(s.maxHeaderBytes()))
+
+	// Wait for the first byte of the request before marking the connection
+	// active, so an idle keep-alive connection can be closed by Shutdown.
+	if _, err := reader.Peek(1); err != nil {
 		return true, fmt.Errorf("read request line error: %w", err)
 	}
-	reqLine := string(reqLineBytes)
-
-	req := new(http.Request)
-	var found bool
+	s.setConnState(conn, stateActive)
 
-	req.Method, reqLine, found = strings.Cut(reqLine, " ")
-	if !found {
-		return true, errors.New("invalid method")
+	// The request has started, so the header timeout is counted from here.
+	t0 := time.Now()
+	var hdrDeadline, wholeReqDeadline time.Time
+	if d := s.readHeaderTimeout(); d > 0 {
+		hdrDeadline = t0.Add(d)
 	}
-	if !methodValid(req.Method) {
-		return true, errors.New("invalid method")
+	if d := s.ReadTimeout; d > 0 {
+		wholeReqDeadline = t0.Add(d)
 	}
+	conn.SetReadDeadline(hdrDeadline)
 
-	req.RequestURI, reqLine, found = strings.Cut(reqLine, " ")
-	if !found {
-		return true, errors.New("invalid path")
-	}
-	if req.URL, err = url.ParseRequestURI(req.RequestURI); err != nil {
-		return true, fmt.Errorf("invalid path: %w", err)
+	req, err := readRequest(c)
+	// The response, even one rejecting the request, starts its own
+	// WriteTimeout clock once the headers are read.
+	if d := s.WriteTimeout; d > 0 {
+		conn.SetWriteDeadline(time.Now().Add(d))
+	} else {
+		conn.SetWriteDeadline(time.Time{})
 	}
-
-	req.Proto = reqLine
-	req.ProtoMajor, req.ProtoMinor, found = parseProtocol(req.Proto)
-	if !found {
-		return true, errors.New("invalid protocol")
+	if err != nil {
+		return true, rejectRequest(c, err)
 	}
-
-	req.Header = make(http.Header)
-	for {
-		line, _, err := reader.ReadLine()
-		if err != nil && err != io.EOF {
-			return true, err
-		} else if err != nil {
-			break
-		}
-		if len(line) == 0 {
-			break
-		}
-
-		k, v, ok := bytes.Cut(line, []byte{':'})
-		if !ok {
-			return true, errors.New("invalid header")
-		}
-		req.Header.Add(strings.ToLower(string(k)), strings.TrimLeft(string(v), " "))
+	framing, err := setFraming(req)
+	if err != nil {
+		return true, rejectRequest(c, err)
 	}
-
-	if _, ok := req.Header["Host"]; !ok {
-		return true, errors.New("required 'Host' header not found")
+	isChunked := framing.chunked
+	// A body we know is too large is turned down before the client sends
+	// it, a chunked one is cut off while the handler reads it.
+	if s.MaxBodyBytes > 0 && req.ContentLength > s.MaxBodyBytes {
+		return true, rejectRequest(c, &RequestError{StatusCode: http.StatusRequestEntityTooLarge, Check: "body too large", Err: &http.MaxBytesError{Limit: s.MaxBodyBytes}})
 	}
 
-	switch strings.ToLower(req.Header.Get("Connection")) {
-	case "keep-alive", "":
-		req.Close = false
-	case "close":
-		req.Close = true
-	}
+	c.r.setInfiniteReadLimit()
 
-	limitReader.N = math.MaxInt64
+	// Headers are in, the rest of the request gets what's left of
+	// ReadTimeout.
+	conn.SetReadDeadline(wholeReqDeadline)
 
 	ctx := context.Background()
+	// Handlers find our *Server there, not an *http.Server.
+	ctx = context.WithValue(ctx, http.ServerContextKey, s)
 	ctx = context.WithValue(ctx, http.LocalAddrContextKey, conn.LocalAddr())
+	var chunkExts *[]ChunkExtension
+	if isChunked {
+		chunkExts = new([]ChunkExtension)
+		ctx = context.WithValue(ctx, chunkExtensionsKey{}, chunkExts)
+	}
 	ctx, cancelCtx := context.WithCancel(ctx)
 	defer cancelCtx()
-	contentLength, err := parseContentLength(req.Header.Get("Content-Length"))
-	if err != nil {
-		return true, err
+	c.r.setCancelCtx(cancelCtx)
+	defer c.r.setCancelCtx(nil)
+	// From here on req is the very value the Handler sees, the body readers
+	// fill in req.Trailer on it.
+	req = req.WithContext(ctx)
+
+	req.RemoteAddr = conn.RemoteAddr().String()
+	req.TLS = c.tlsState
+
+	w := &responseBodyWriter{
+		req:  req,
+		conn: c,
+		bufw: c.bufw,
+		// Don't keep the connection alive when the server is going away, or
+		// after a request with ambiguous framing.
+		closeAfterReply: req.Close || framing.ambiguous || s.shuttingDown(),
+		headers:         make(http.Header),
+		bufferLimit:     s.responseBufferSize(),
+	}
+
+	// Once the handler has read the whole body we can watch the connection
+	// for the client going away, until the handler returns.
+	var handlerDone atomic.Bool
+	startBackgroundRead := func() {
+		if !handlerDone.Load() && !c.hijacked.Load() {
+			c.r.startBackgroundRead()
+		}
 	}
-	req.ContentLength = contentLength
-	isChunked := req.Header.Get("Transfer-Encoding") == "chunked"
 	if req.ContentLength == 0 && !isChunked {
-		req.Body = noBody{}
+		req.Body = http.NoBody
 	} else {
+		var body io.ReadCloser
 		if isChunked {
-			req.Body = &chunkedBodyReader{
+			body = &chunkedBodyReader{
 				reader: reader,
+				req:    req,
+				exts:   chunkExts,
+			}
+			if s.MaxBodyBytes > 0 {
+				body = &maxBytesBody{body: body, remain: s.MaxBodyBytes, limit: s.MaxBodyBytes, onHit: w.requestTooLarge}
 			}
 		} else {
-			req.Body = &bodyReader{
+			body = &bodyReader{
 				reader: io.LimitReader(reader, req.ContentLength),
+				n:      req.ContentLength,
 			}
 		}
+		req.Body = &eofSignalBody{body: body, onEOF: startBackgroundRead}
 	}
 
-	req.RemoteAddr = conn.RemoteAddr().String()
+	// The client holds back the body until we say "100 Continue", which we
+	// only do once the handler starts reading it.
+	if expect := req.Header.Get("Expect"); expect != "" {
+		if !strings.EqualFold(expect, "100-continue") {
+			return true, rejectRequest(c, &RequestError{StatusCode: http.StatusExpectationFailed, Check: "unsupported expectation", Err: fmt.Errorf("expectation %q", expect)})
+		}
+		if req.ProtoAtLeast(1, 1) && req.Body != http.NoBody {
+			req.Body = &expectContinueReader{body: req.Body, w: w}
+		}
+	}
 
-	w := &responseBodyWriter{
-		req:     req,
-		conn:    conn,
-		headers: make(http.Header),
+	w.reqBody = req.Body
+
+	if req.Body == http.NoBody {
+		startBackgroundRead()
+	}
+	s.Handler.ServeHTTP(w, req)
+	handlerDone.Store(true)
+	if c.hijacked.Load() {
+		return true, nil
+	}
+	if w.requestBodyLimitHit && !w.wroteHeader {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+	}
+	flushErr := w.flush()
+	c.r.abortPendingRead()
+	if flushErr != nil {
+		return true, nil
+	}
+
+	if w.closeAfterReply || bodyHeldBack(w.reqBody) {
+		return true, nil
 	}
 
-	s.Handler.ServeHTTP(w, req.WithContext(ctx))
-	if err := w.flush(); err != nil {
+	// Consume whatever the handler left of the body, the next request on
+	// this connection starts right after it. Like net/http we read at most
+	// maxPostHandlerReadBytes of it, with more left it's cheaper to close.
+	if n, err := io.CopyN(io.Discard, w.reqBody, maxPostHandlerReadBytes+1); err != io.EOF || n > maxPostHandlerReadBytes {
+		httperr.CloseWriteAndWait(conn)
 		return true, nil
 	}
-	return req.Close, nil
+	return false, nil
+}
+
+// maxPostHandlerReadBytes is how much of a request body we read after the
+// handler returns to keep the connection alive.
+const maxPostHandlerReadBytes = 256 << 10
+
+// readRequest reads the request line and headers of the next request.
+// Requests we can't accept are reported as a *RequestError.
+func readRequest(c *conn) (*http.Request, error) {
+	reqLineBytes, err := readLine(c)
+	if err != nil {
+		return nil, fmt.Errorf("read request line error: %w", err)
+	}
+	reqLine, err := parser.ParseRequestLine(reqLineBytes)
+	if err != nil {
+		return nil, syntaxError(err)
+	}
+
+	req := &http.Request{
+		Method:     reqLine.Method,
+		RequestURI: reqLine.Target,
+		Proto:      reqLine.Proto,
+		ProtoMajor: reqLine.ProtoMajor,
+		ProtoMinor: reqLine.ProtoMinor,
+	}
+	if !methodValid(req.Method) {
+		return nil, &RequestError{StatusCode: http.StatusNotImplemented, Check: "invalid method", Err: fmt.Errorf("unsupported method %q", req.Method)}
+	}
+	// CONNECT takes an authority, host:port, instead of a path. Parse it the
+	// way net/http does.
+	rawURL := req.RequestURI
+	justAuthority := req.Method == http.MethodConnect && !strings.HasPrefix(rawURL, "/")
+	if justAuthority {
+		rawURL = "http://" + rawURL
+	}
+	if req.URL, err = url.ParseRequestURI(rawURL); err != nil {
+		return nil, badRequest("invalid path", err)
+	}
+	if justAuthority {
+		req.URL.Scheme = ""
+	}
+	// The version is well formed, but one we may not speak.
+	if _, _, ok := parseProtocol(req.Proto); !ok {
+		return nil, &RequestError{StatusCode: http.StatusHTTPVersionNotSupported, Check: "invalid protocol", Err: fmt.Errorf("unsupported protocol %q", req.Proto)}
+	}
+
+	req.Header = make(http.Header)
+	maxCount := c.server.maxHeaderCount()
+	for count := 0; ; count++ {
+		line, err := readLine(c)
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 {
+			break
+		}
+		if count == maxCount {
+			return nil, &RequestError{StatusCode: http.StatusRequestHeaderFieldsTooLarge, Check: "too many headers", Err: fmt.Errorf("more than %d header fields", maxCount)}
+		}
+
+		k, v, err := parser.ParseHeaderField(line)
+		if err != nil {
+			return nil, syntaxError(err)
+		}
+		req.Header.Add(k, v)
+	}
+
+	// HTTP/1.0 clients may leave out Host, HTTP/1.1 ones must send exactly
+	// one. The host moves from the header to req.Host, preferring the one
+	// of an absolute request URI.
+	hosts, haveHost := req.Header["Host"]
+	if req.ProtoAtLeast(1, 1) && !haveHost {
+		return nil, badRequest("missing Host header", nil)
+	}
+	if len(hosts) > 1 {
+		return nil, badRequest("too many Host headers", nil)
+	}
+	req.Host = req.URL.Host
+	if req.Host == "" && len(hosts) == 1 {
+		req.Host = hosts[0]
+	}
+	req.Header.Del("Host")
+
+	// A lone "Pragma: no-cache" from an HTTP/1.0 cache means Cache-Control:
+	// no-cache, see RFC 9111 section 5.4.
+	if len(req.Header["Pragma"]) > 0 && req.Header.Get("Pragma") == "no-cache" {
+		if _, ok := req.Header["Cache-Control"]; !ok {
+			req.Header.Set("Cache-Control", "no-cache")
+		}
+	}
+
+	req.Close = shouldClose(req)
+
+	return req, nil
+}
+
+// readLine reads one line of the request head. Running into the header
+// size limit is reported as a *RequestError, a connection closed mid-line as
+// io.ErrUnexpectedEOF.
+func readLine(c *conn) ([]byte, error) {
+	line, err := parser.ReadLine(c.bufr)
+	if err != nil {
+		if c.r.hitReadLimit() {
+			return nil, &RequestError{StatusCode: http.StatusRequestHeaderFieldsTooLarge, Check: "headers too large"}
+		}
+		return nil, syntaxError(err)
+	}
+	return line, nil
+}
+
+// syntaxError turns a *parser.SyntaxError into a 400 named after the part
+// of the request head it is about. Other errors are returned as is.
+func syntaxError(err error) error {
+	var synErr *parser.SyntaxError
+	if !errors.As(err, &synErr) {
+		return err
+	}
+	switch synErr.Part {
+	case parser.PartMethod:
+		return badRequest("invalid method", err)
+	case parser.PartRequestTarget:
+		return badRequest("invalid path", err)
+	case parser.PartHTTPVersion:
+		return badRequest("invalid protocol", err)
+	case parser.PartFieldName, parser.PartFieldValue:
+		return badRequest("invalid header", err)
+	}
+	return badRequest("invalid line", err)
+}
+
+// rejectRequest answers a request that failed one of our checks. Other
+// errors, like the client going away, are returned untouched.
+func rejectRequest(c *conn, err error) error {
+	var reqErr *RequestError
+	if errors.As(err, &reqErr) {
+		writeErrorResponse(c.netConn, reqErr)
+		// The client may still be sending.
+		httperr.CloseWriteAndWait(c.netConn)
+	}
+	return err
 }
 
-func parseContentLength(headerval string) (int64, error) {
-	if headerval == "" {
-		return 0, nil
+// shouldClose reports whether the client wants the connection closed after
+// this request. HTTP/1.0 connections only persist when asked for, HTTP/1.1
+// ones unless asked not to.
+func shouldClose(req *http.Request) bool {
+	conv := req.Header["Connection"]
+	hasClose := headerValuesContainsToken(conv, "close")
+	if !req.ProtoAtLeast(1, 1) {
+		return hasClose || !headerValuesContainsToken(conv, "keep-alive")
 	}
-	return strconv.ParseInt(headerval, 10, 64)
+	return hasClose
+}
+
+// headerValuesContainsToken reports whether any of the comma separated
+// values contains token, ignoring case.
+func headerValuesContainsToken(values []string, token string) bool {
+	for _, v := range values {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 func parseProtocol(proto string) (int, int, bool) {
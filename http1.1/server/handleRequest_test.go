@@ -0,0 +1,51 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/kianooshaz/http-from-scratch/internal/servertest"
+)
+
+func TestRequestMatchesNetHTTP(t *testing.T) {
+	got := make(chan servertest.Request, 1)
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got <- servertest.Snapshot(r)
+	})
+	_, addr := newTestServer(t, h)
+	ref := httptest.NewServer(h)
+	t.Cleanup(ref.Close)
+	refAddr := ref.Listener.Addr().String()
+
+	tests := []struct {
+		name string
+		raw  string
+	}{
+		{"get", "GET /a?b=c HTTP/1.1\r\nHost: example.com\r\nUser-Agent: test\r\n\r\n"},
+		{"absolute uri", "GET http://example.com/a HTTP/1.1\r\nHost: other.example\r\n\r\n"},
+		{"host with port", "GET / HTTP/1.1\r\nHost: example.com:8080\r\n\r\n"},
+		{"connection close", "GET / HTTP/1.1\r\nHost: a\r\nConnection: close\r\n\r\n"},
+		{"connection tokens", "GET / HTTP/1.1\r\nHost: a\r\nConnection: keep-alive, Upgrade\r\n\r\n"},
+		{"connect", "CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n"},
+		{"http/1.0", "GET / HTTP/1.0\r\n\r\n"},
+		{"http/1.0 keep-alive", "GET / HTTP/1.0\r\nHost: a\r\nConnection: keep-alive\r\n\r\n"},
+		{"header whitespace", "GET / HTTP/1.1\r\nHost: a\r\nx-lower: \t padded \t\r\nX-Dup: 1\r\nX-Dup: 2\r\n\r\n"},
+		{"pragma", "GET / HTTP/1.1\r\nHost: a\r\nPragma: no-cache\r\n\r\n"},
+		{"content-length body", "POST / HTTP/1.1\r\nHost: a\r\nContent-Type: text/plain\r\nContent-Length: 5\r\n\r\nhello"},
+		{"chunked body", "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\n\r\n"},
+		{"trailer without chunked", "POST / HTTP/1.1\r\nHost: a\r\nTrailer: X-Sum\r\nContent-Length: 5\r\n\r\nhello"},
+		{"trailers", "POST / HTTP/1.1\r\nHost: a\r\nTrailer: X-Sum\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\nX-Sum: 42\r\n\r\n"},
+		{"expect continue", "PUT / HTTP/1.1\r\nHost: a\r\nExpect: 100-continue\r\nContent-Length: 5\r\n\r\nhello"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := servertest.Capture(t, refAddr, tt.raw, got)
+			have := servertest.Capture(t, addr, tt.raw, got)
+			if !reflect.DeepEqual(have, want) {
+				t.Errorf("request differs from net/http\n got: %+v\nwant: %+v", have, want)
+			}
+		})
+	}
+}
@@ -1,31 +1,183 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
 	"log/slog"
 	"net"
 	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// ErrServerClosed is returned by ListenAndServe after a call to Shutdown or
+// Close. It is the same value as http.ErrServerClosed so callers can keep
+// checking against the net/http error.
+var ErrServerClosed = http.ErrServerClosed
+
+// DefaultResponseBufferSize is used when Server.ResponseBufferSize is zero.
+const DefaultResponseBufferSize = 2048
+
+// DefaultMaxHeaderBytes is used when Server.MaxHeaderBytes is zero.
+const DefaultMaxHeaderBytes = 1 << 20
+
+// DefaultMaxHeaderCount is used when Server.MaxHeaderCount is zero.
+const DefaultMaxHeaderCount = 100
+
+// shutdownPollInterval is how often Shutdown checks for connections that
+// have gone idle.
+const shutdownPollInterval = 500 * time.Millisecond
+
+// newConnGracePeriod is how long Shutdown leaves a new connection alone
+// before treating it as idle, like net/http does.
+const newConnGracePeriod = 5 * time.Second
+
+type connState int
+
+const (
+	// stateNew means the connection has been accepted but hasn't sent its
+	// first request yet. It may still be in the TLS handshake, or its
+	// request may be on the way.
+	stateNew connState = iota
+	// stateIdle means the connection is waiting for the next request.
+	stateIdle
+	// stateActive means a request is being read or served.
+	stateActive
+)
+
+// trackedConn is the state of a connection and when it entered it.
+type trackedConn struct {
+	state connState
+	since time.Time
+}
+
 type Server struct {
 	Addr    string
 	Handler http.Handler
+
+	// TLSConfig optionally provides a TLS configuration for use by
+	// ListenAndServeTLS. It is cloned before use, so it is safe to share.
+	TLSConfig *tls.Config
+
+	// ReadTimeout is the maximum duration for reading the entire request,
+	// including the body. Zero or negative means no timeout.
+	ReadTimeout time.Duration
+
+	// ReadHeaderTimeout is the amount of time allowed to read the request
+	// line and headers, counted from the first byte of the request. A new
+	// connection must also send that first byte within this time. If zero,
+	// ReadTimeout is used.
+	ReadHeaderTimeout time.Duration
+
+	// WriteTimeout is the maximum duration before timing out writes of the
+	// response. It is reset whenever a new request's headers are read.
+	WriteTimeout time.Duration
+
+	// IdleTimeout is the maximum amount of time to wait for the next request
+	// on a keep-alive connection. If zero, ReadTimeout is used.
+	IdleTimeout time.Duration
+
+	// ResponseBufferSize is how many bytes of a response body are held back
+	// before the headers are sent. A handler that finishes within it gets a
+	// Content-Length header, a longer body is sent chunked. If zero,
+	// DefaultResponseBufferSize is used. Negative disables buffering.
+	ResponseBufferSize int
+
+	// MaxHeaderBytes caps the size of the request line and headers, a
+	// request over it gets a 431. If zero, DefaultMaxHeaderBytes is used.
+	MaxHeaderBytes int
+
+	// MaxHeaderCount caps the number of header fields of a request, a
+	// request over it gets a 431. If zero, DefaultMaxHeaderCount is used.
+	MaxHeaderCount int
+
+	// MaxBodyBytes caps the size of a request body. A request announcing a
+	// longer one gets a 413 without reaching the Handler. Reading a chunked
+	// body past it fails with an *http.MaxBytesError; if the Handler then
+	// doesn't respond, the client gets a 413. Zero or negative means no
+	// limit.
+	MaxBodyBytes int64
+
+	mu         sync.Mutex
+	listeners  map[net.Listener]struct{}
+	activeConn map[net.Conn]trackedConn
+	inShutdown atomic.Bool
 }
 
 func (s *Server) ListenAndServe() error {
-	if s.Handler == nil {
-		s.Handler = http.DefaultServeMux
+	if s.shuttingDown() {
+		return ErrServerClosed
+	}
+
+	l, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// ListenAndServeTLS is like ListenAndServe but terminates TLS on every
+// accepted connection. certFile and keyFile must hold a PEM encoded
+// certificate and its key unless TLSConfig already provides certificates,
+// in which case they may be empty. The certificate file should be the
+// concatenation of the server's certificate, any intermediates, and the
+// CA's certificate.
+func (s *Server) ListenAndServeTLS(certFile, keyFile string) error {
+	if s.shuttingDown() {
+		return ErrServerClosed
+	}
+
+	config := &tls.Config{}
+	if s.TLSConfig != nil {
+		config = s.TLSConfig.Clone()
+	}
+	// Keep the caller's protocols, but make sure a client asking for
+	// HTTP/1.1 by ALPN gets it.
+	if !slices.Contains(config.NextProtos, "http/1.1") {
+		config.NextProtos = append(config.NextProtos, "http/1.1")
+	}
+
+	configHasCert := len(config.Certificates) > 0 || config.GetCertificate != nil || config.GetConfigForClient != nil
+	if !configHasCert || certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return err
+		}
+		config.Certificates = append(config.Certificates, cert)
 	}
 
 	l, err := net.Listen("tcp", s.Addr)
 	if err != nil {
 		return err
 	}
+	return s.Serve(tls.NewListener(l, config))
+}
+
+// Serve accepts connections on l and handles each in its own goroutine.
+// Any net.Listener works: TCP, Unix sockets, sockets handed over by
+// systemd, or in-memory listeners in tests. Connections from a listener
+// wrapped with tls.NewListener are served over TLS. Serve always returns a
+// non-nil error and closes l; after Shutdown or Close it is ErrServerClosed.
+func (s *Server) Serve(l net.Listener) error {
 	defer l.Close()
+	if s.Handler == nil {
+		s.Handler = http.DefaultServeMux
+	}
+
+	if !s.trackListener(l, true) {
+		return ErrServerClosed
+	}
+	defer s.trackListener(l, false)
 
 	for {
 		conn, err := l.Accept()
 		if err != nil {
+			if s.shuttingDown() {
+				return ErrServerClosed
+			}
 			return err
 		}
 
@@ -36,3 +188,164 @@ func (s *Server) ListenAndServe() error {
 		}()
 	}
 }
+
+// Shutdown stops the server without interrupting in-flight requests. It
+// closes all listeners, then closes connections as soon as they are idle,
+// and waits until every connection is gone or ctx is done, in which case
+// ctx's error is returned. A connection that hasn't sent its first request
+// yet is given five seconds to do so. Once Shutdown has been called, ListenAndServe
+// returns ErrServerClosed.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.inShutdown.Store(true)
+
+	s.mu.Lock()
+	err := s.closeListenersLocked()
+	s.mu.Unlock()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		if s.closeIdleConns() {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Close immediately closes all listeners and connections, including those
+// with a request in progress. Use Shutdown to let in-flight requests finish.
+func (s *Server) Close() error {
+	s.inShutdown.Store(true)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.closeListenersLocked()
+	for c := range s.activeConn {
+		c.Close()
+		delete(s.activeConn, c)
+	}
+	return err
+}
+
+func (s *Server) readHeaderTimeout() time.Duration {
+	if s.ReadHeaderTimeout != 0 {
+		return s.ReadHeaderTimeout
+	}
+	return s.ReadTimeout
+}
+
+func (s *Server) idleTimeout() time.Duration {
+	if s.IdleTimeout != 0 {
+		return s.IdleTimeout
+	}
+	return s.ReadTimeout
+}
+
+func (s *Server) responseBufferSize() int {
+	if s.ResponseBufferSize == 0 {
+		return DefaultResponseBufferSize
+	}
+	return s.ResponseBufferSize
+}
+
+func (s *Server) maxHeaderBytes() int {
+	if s.MaxHeaderBytes > 0 {
+		return s.MaxHeaderBytes
+	}
+	return DefaultMaxHeaderBytes
+}
+
+func (s *Server) maxHeaderCount() int {
+	if s.MaxHeaderCount > 0 {
+		return s.MaxHeaderCount
+	}
+	return DefaultMaxHeaderCount
+}
+
+func (s *Server) shuttingDown() bool {
+	return s.inShutdown.Load()
+}
+
+// trackListener adds or removes l from the set of listeners closed on
+// shutdown. Adding reports false if the server is already shutting down.
+func (s *Server) trackListener(l net.Listener, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if add {
+		if s.shuttingDown() {
+			return false
+		}
+		if s.listeners == nil {
+			s.listeners = make(map[net.Listener]struct{})
+		}
+		s.listeners[l] = struct{}{}
+	} else {
+		delete(s.listeners, l)
+	}
+	return true
+}
+
+func (s *Server) closeListenersLocked() error {
+	var err error
+	for l := range s.listeners {
+		if cerr := l.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+		delete(s.listeners, l)
+	}
+	return err
+}
+
+// trackConn adds or removes conn from the set of connections closed on
+// shutdown. Adding reports false if the server is already shutting down.
+func (s *Server) trackConn(conn net.Conn, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if add {
+		if s.shuttingDown() {
+			return false
+		}
+		if s.activeConn == nil {
+			s.activeConn = make(map[net.Conn]trackedConn)
+		}
+		s.activeConn[conn] = trackedConn{state: stateNew, since: time.Now()}
+	} else {
+		delete(s.activeConn, conn)
+	}
+	return true
+}
+
+func (s *Server) setConnState(conn net.Conn, state connState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.activeConn[conn]; ok {
+		s.activeConn[conn] = trackedConn{state: state, since: time.Now()}
+	}
+}
+
+// closeIdleConns closes all idle connections and reports whether the server
+// has no connections left. New connections count as idle once they have
+// been quiet for newConnGracePeriod.
+func (s *Server) closeIdleConns() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	quiescent := true
+	now := time.Now()
+	for c, tc := range s.activeConn {
+		state := tc.state
+		if state == stateNew && now.Sub(tc.since) >= newConnGracePeriod {
+			state = stateIdle
+		}
+		if state != stateIdle {
+			quiescent = false
+			continue
+		}
+		c.Close()
+		delete(s.activeConn, c)
+	}
+	return quiescent
+}
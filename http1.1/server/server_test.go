@@ -0,0 +1,275 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestServer starts a Server with h on a loopback port and returns the
+// address to dial.
+func newTestServer(t *testing.T, h http.Handler) (*Server, string) {
+	t.Helper()
+
+	s := &Server{Handler: h}
+	return s, startTestServer(t, s)
+}
+
+// startTestServer serves s on a loopback port and returns the address to
+// dial. Configure s before calling it.
+func startTestServer(t *testing.T, s *Server) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go s.Serve(l)
+	t.Cleanup(func() { s.Close() })
+	return l.Addr().String()
+}
+
+// dial opens a raw connection to addr that is closed when the test ends.
+func dial(t *testing.T, addr string) net.Conn {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	// Fail instead of hanging when the server doesn't answer.
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return conn
+}
+
+func TestServeUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "http.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Skipf("unix sockets unavailable: %v", err)
+	}
+
+	s := &Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello over "+r.Context().Value(http.LocalAddrContextKey).(net.Addr).Network())
+	})}
+	errc := make(chan error, 1)
+	go func() { errc <- s.Serve(l) }()
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if want := "hello over unix"; string(body) != want {
+		t.Errorf("body = %q, want %q", body, want)
+	}
+
+	s.Close()
+	if err := <-errc; err != ErrServerClosed {
+		t.Errorf("Serve = %v, want %v", err, ErrServerClosed)
+	}
+}
+
+func TestServerLimits(t *testing.T) {
+	s := &Server{
+		MaxHeaderBytes: 256,
+		MaxHeaderCount: 3,
+		MaxBodyBytes:   5,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, err := io.ReadAll(r.Body)
+			var mbe *http.MaxBytesError
+			switch {
+			case errors.As(err, &mbe):
+				if r.URL.Path == "/answer" {
+					http.Error(w, "body over "+strconv.FormatInt(mbe.Limit, 10)+" bytes", http.StatusBadRequest)
+				}
+			case err != nil:
+				t.Errorf("reading body: %v", err)
+			case r.URL.Path != "/ok":
+				t.Errorf("handler called for %s", r.URL.Path)
+			}
+		}),
+	}
+	addr := startTestServer(t, s)
+
+	tests := []struct {
+		name       string
+		request    string
+		wantStatus int
+		wantBody   string
+		wantClose  bool
+	}{
+		{"headers at limit", "GET /ok HTTP/1.1\r\nHost: a\r\nX-A: 1\r\nX-B: 2\r\n\r\n", http.StatusOK, "", false},
+		{"too many headers", "GET / HTTP/1.1\r\nHost: a\r\nX-A: 1\r\nX-B: 2\r\nX-C: 3\r\n\r\n", http.StatusRequestHeaderFieldsTooLarge, "too many headers", true},
+		{"headers too large", "GET / HTTP/1.1\r\nHost: a\r\nX-Big: " + strings.Repeat("a", 256) + "\r\n\r\n", http.StatusRequestHeaderFieldsTooLarge, "headers too large", true},
+		{"body at limit", "POST /ok HTTP/1.1\r\nHost: a\r\nContent-Length: 5\r\n\r\nhello", http.StatusOK, "", false},
+		{"announced body too large", "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 6\r\n\r\nhello!", http.StatusRequestEntityTooLarge, "body too large", true},
+		{"chunked body at limit", "POST /ok HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nhel\r\n2\r\nlo\r\n0\r\n\r\n", http.StatusOK, "", false},
+		{"chunked body too large", "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nhel\r\n3\r\nlo!\r\n0\r\n\r\n", http.StatusRequestEntityTooLarge, "", true},
+		{"handler answers", "POST /answer HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n6\r\nhello!\r\n0\r\n\r\n", http.StatusBadRequest, "body over 5 bytes\n", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			conn := dial(t, addr)
+			go io.WriteString(conn, tt.request)
+
+			resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if !strings.HasSuffix(string(body), tt.wantBody) {
+				t.Errorf("body = %q, want it to end in %q", body, tt.wantBody)
+			}
+			if resp.Close != tt.wantClose {
+				t.Errorf("Close = %v, want %v", resp.Close, tt.wantClose)
+			}
+		})
+	}
+}
+
+// waitForConns waits until s tracks n connections.
+func waitForConns(t *testing.T, s *Server, n int) {
+	t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		s.mu.Lock()
+		got := len(s.activeConn)
+		s.mu.Unlock()
+		if got == n {
+			return
+		}
+	}
+	t.Fatalf("server never had %d connections", n)
+}
+
+func TestShutdown(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	s := &Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			close(started)
+			<-release
+		}
+		io.WriteString(w, "done")
+	})}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- s.Serve(l) }()
+	addr := l.Addr().String()
+
+	// One keep-alive connection that has been served and is idle, one
+	// with a request in flight.
+	idle := dial(t, addr)
+	idleBr := bufio.NewReader(idle)
+	io.WriteString(idle, "GET / HTTP/1.1\r\nHost: a\r\n\r\n")
+	resp, err := http.ReadResponse(idleBr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.ReadAll(resp.Body)
+	busy := dial(t, addr)
+	io.WriteString(busy, "GET /slow HTTP/1.1\r\nHost: a\r\n\r\n")
+	<-started
+
+	shutdownErr := make(chan error, 1)
+	go func() { shutdownErr <- s.Shutdown(context.Background()) }()
+
+	if err := <-serveErr; err != ErrServerClosed {
+		t.Errorf("Serve = %v, want %v", err, ErrServerClosed)
+	}
+	if _, err := idleBr.ReadByte(); err != io.EOF {
+		t.Errorf("reading the idle connection = %v, want %v", err, io.EOF)
+	}
+	select {
+	case err := <-shutdownErr:
+		t.Fatalf("Shutdown returned %v with a request in flight", err)
+	default:
+	}
+
+	close(release)
+	resp, err = http.ReadResponse(bufio.NewReader(busy), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "done" || !resp.Close {
+		t.Errorf("in-flight request got %q, close %v, want %q and close", body, resp.Close, "done")
+	}
+	if err := <-shutdownErr; err != nil {
+		t.Errorf("Shutdown = %v, want nil", err)
+	}
+	if err := s.ListenAndServe(); err != ErrServerClosed {
+		t.Errorf("ListenAndServe after Shutdown = %v, want %v", err, ErrServerClosed)
+	}
+}
+
+func TestShutdownContextDeadline(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	s, addr := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}))
+
+	conn := dial(t, addr)
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: a\r\n\r\n")
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Shutdown = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+// TestShutdownSparesNewConns checks that a connection that hasn't sent
+// its first request yet isn't mistaken for an idle one.
+func TestShutdownSparesNewConns(t *testing.T) {
+	s, addr := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "served")
+	}))
+
+	conn := dial(t, addr)
+	waitForConns(t, s, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Shutdown = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: a\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "served" || !resp.Close {
+		t.Errorf("got %q, close %v, want %q and close", body, resp.Close, "served")
+	}
+}
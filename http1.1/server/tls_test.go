@@ -0,0 +1,205 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// writeSelfSignedCert generates a certificate for 127.0.0.1 and writes it
+// and its key as PEM files into a temporary directory.
+func writeSelfSignedCert(t *testing.T) (certFile, keyFile string, pool *x509.CertPool) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{Organization: []string{"http-from-scratch test"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		DNSNames:              []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool = x509.NewCertPool()
+	pool.AddCert(cert)
+	return certFile, keyFile, pool
+}
+
+// waitForListener returns the address of the first listener s tracks.
+func waitForListener(t *testing.T, s *Server) string {
+	t.Helper()
+
+	for range 500 {
+		s.mu.Lock()
+		for l := range s.listeners {
+			s.mu.Unlock()
+			return l.Addr().String()
+		}
+		s.mu.Unlock()
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("server never started listening")
+	return ""
+}
+
+func TestListenAndServeTLS(t *testing.T) {
+	certFile, keyFile, pool := writeSelfSignedCert(t)
+
+	s := &Server{
+		Addr: "127.0.0.1:0",
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.TLS == nil {
+				io.WriteString(w, "no TLS")
+				return
+			}
+			fmt.Fprintf(w, "%s %s", tls.VersionName(r.TLS.Version), r.TLS.ServerName)
+		}),
+	}
+	errc := make(chan error, 1)
+	go func() { errc <- s.ListenAndServeTLS(certFile, keyFile) }()
+	t.Cleanup(func() {
+		s.Close()
+		if err := <-errc; err != ErrServerClosed {
+			t.Errorf("ListenAndServeTLS = %v, want %v", err, ErrServerClosed)
+		}
+	})
+	_, port, _ := net.SplitHostPort(waitForListener(t, s))
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS13},
+	}}
+	defer client.CloseIdleConnections()
+
+	// Two requests so the second one runs over the kept-alive TLS connection.
+	for range 2 {
+		resp, err := client.Get("https://localhost:" + port + "/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if want := "TLS 1.3 localhost"; string(body) != want {
+			t.Errorf("body = %q, want %q", body, want)
+		}
+		if resp.ProtoMajor != 1 || resp.ProtoMinor != 1 {
+			t.Errorf("negotiated %s, want HTTP/1.1", resp.Proto)
+		}
+	}
+}
+
+func TestListenAndServeTLSConfigCertificates(t *testing.T) {
+	certFile, keyFile, pool := writeSelfSignedCert(t)
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Server{
+		Addr:      "127.0.0.1:0",
+		TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}},
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "ok")
+		}),
+	}
+	go s.ListenAndServeTLS("", "")
+	t.Cleanup(func() { s.Close() })
+	addr := waitForListener(t, s)
+
+	conn, err := tls.Dial("tcp", addr, &tls.Config{RootCAs: pool, ServerName: "127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if got := conn.ConnectionState().NegotiatedProtocol; got != "" && got != "http/1.1" {
+		t.Errorf("negotiated protocol %q", got)
+	}
+
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: 127.0.0.1\r\nConnection: close\r\n\r\n")
+	resp, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp) < 12 || string(resp[:12]) != "HTTP/1.1 200" {
+		t.Errorf("unexpected response %q", resp)
+	}
+}
+
+func TestListenAndServeTLSMissingCert(t *testing.T) {
+	s := &Server{Addr: "127.0.0.1:0"}
+	if err := s.ListenAndServeTLS("", ""); err == nil {
+		t.Fatal("ListenAndServeTLS without any certificate succeeded")
+	}
+}
+
+func TestListenAndServeTLSKeepsNextProtos(t *testing.T) {
+	certFile, keyFile, pool := writeSelfSignedCert(t)
+
+	config := &tls.Config{NextProtos: []string{"acme-tls/1"}}
+	s := &Server{
+		Addr:      "127.0.0.1:0",
+		TLSConfig: config,
+		Handler:   http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+	}
+	go s.ListenAndServeTLS(certFile, keyFile)
+	t.Cleanup(func() { s.Close() })
+	addr := waitForListener(t, s)
+
+	for _, proto := range []string{"acme-tls/1", "http/1.1"} {
+		conn, err := tls.Dial("tcp", addr, &tls.Config{RootCAs: pool, ServerName: "127.0.0.1", NextProtos: []string{proto}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := conn.ConnectionState().NegotiatedProtocol; got != proto {
+			t.Errorf("negotiated %q, want %q", got, proto)
+		}
+		conn.Close()
+	}
+	if want := []string{"acme-tls/1"}; !slices.Equal(config.NextProtos, want) {
+		t.Errorf("TLSConfig.NextProtos = %q, want %q", config.NextProtos, want)
+	}
+}
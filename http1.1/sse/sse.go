@@ -0,0 +1,190 @@
+// Package sse streams server-sent events (the text/event-stream format)
+// over a response from the http1.1 server, or any other http.ResponseWriter
+// that can flush.
+package sse
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrClosed is returned when sending on a stream after Close.
+var ErrClosed = errors.New("sse: stream closed")
+
+// Stream writes events to one client. It is safe for concurrent use, so a
+// heartbeat can run alongside the handler's own Sends.
+type Stream struct {
+	mu     sync.Mutex
+	rc     *http.ResponseController
+	w      http.ResponseWriter
+	ctx    context.Context
+	closed bool
+
+	lastEventID string
+
+	stopHeartbeat chan struct{}
+	heartbeatDone chan struct{}
+}
+
+// NewStream sends the headers of an event stream and flushes them, so the
+// client knows the stream is open before the first event. It fails if w
+// can't flush. The stream ends when the client goes away, see Done.
+func NewStream(w http.ResponseWriter, r *http.Request) (*Stream, error) {
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	s := &Stream{
+		rc:          http.NewResponseController(w),
+		w:           w,
+		ctx:         r.Context(),
+		lastEventID: r.Header.Get("Last-Event-ID"),
+	}
+	if err := s.rc.Flush(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// LastEventID is the id of the last event a reconnecting client saw, from
+// its Last-Event-ID header. It is empty on a first connect. Handlers use it
+// to resume the stream after that event.
+func (s *Stream) LastEventID() string {
+	return s.lastEventID
+}
+
+// Done is closed when the client disconnects or the handler is done.
+func (s *Stream) Done() <-chan struct{} {
+	return s.ctx.Done()
+}
+
+// Send writes one event and flushes it. event and id may be empty; an empty
+// event is dispatched by the client as "message". A multi-line data, split
+// at CRLF, CR or LF, is sent as several data lines, which the client joins
+// back with newlines.
+func (s *Stream) Send(event, id, data string) error {
+	if strings.ContainsAny(event, "\r\n") {
+		return errors.New("sse: event name contains a newline")
+	}
+	if strings.ContainsAny(id, "\r\n\x00") {
+		return errors.New("sse: event id contains a newline or NUL")
+	}
+
+	var b strings.Builder
+	if event != "" {
+		b.WriteString("event: " + event + "\n")
+	}
+	if id != "" {
+		b.WriteString("id: " + id + "\n")
+	}
+	for _, line := range splitLines(data) {
+		b.WriteString("data: " + line + "\n")
+	}
+	b.WriteString("\n")
+	return s.write(b.String())
+}
+
+// Retry tells the client how long to wait before reconnecting once the
+// connection drops.
+func (s *Stream) Retry(d time.Duration) error {
+	return s.write("retry: " + strconv.FormatInt(d.Milliseconds(), 10) + "\n\n")
+}
+
+// Comment writes a comment line, which clients ignore. It keeps proxies and
+// load balancers from timing out a quiet stream.
+func (s *Stream) Comment(text string) error {
+	var b strings.Builder
+	for _, line := range splitLines(text) {
+		b.WriteString(": " + line + "\n")
+	}
+	b.WriteString("\n")
+	return s.write(b.String())
+}
+
+// splitLines splits s at CRLF, CR and LF, all of which end a line in an
+// event stream. Leaving a bare CR in would let s start fields of its own.
+func splitLines(s string) []string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	s = strings.ReplaceAll(s, "\r", "\n")
+	return strings.Split(s, "\n")
+}
+
+// Heartbeat sends an empty comment every interval until the stream is
+// closed or the client goes away. Calling it again replaces the previous
+// heartbeat, an interval <= 0 just stops it.
+func (s *Stream) Heartbeat(interval time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.stopHeartbeatLocked()
+	if interval <= 0 {
+		return
+	}
+
+	stop, done := make(chan struct{}), make(chan struct{})
+	s.stopHeartbeat, s.heartbeatDone = stop, done
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-s.ctx.Done():
+				return
+			case <-ticker.C:
+				if err := s.write(":\n\n"); err != nil {
+					return
+				}
+			}
+		}
+	}()
+}
+
+// Close stops the heartbeat and any further writes. A handler must call it
+// before it returns, the ResponseWriter can't be used after that.
+func (s *Stream) Close() {
+	s.mu.Lock()
+	s.closed = true
+	done := s.heartbeatDone
+	s.stopHeartbeatLocked()
+	s.mu.Unlock()
+
+	// The heartbeat may be blocked on the lock, wait for it outside.
+	if done != nil {
+		<-done
+	}
+}
+
+// stopHeartbeatLocked stops a running heartbeat without waiting for it. A
+// tick that is already waiting for s.mu may still send one more comment.
+func (s *Stream) stopHeartbeatLocked() {
+	if s.stopHeartbeat != nil {
+		close(s.stopHeartbeat)
+		s.stopHeartbeat, s.heartbeatDone = nil, nil
+	}
+}
+
+func (s *Stream) write(msg string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	if err := s.ctx.Err(); err != nil {
+		return err
+	}
+	if _, err := s.w.Write([]byte(msg)); err != nil {
+		return err
+	}
+	return s.rc.Flush()
+}
@@ -0,0 +1,217 @@
+package sse
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kianooshaz/http-from-scratch/http1.1/server"
+)
+
+func startServer(t *testing.T, h http.HandlerFunc) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &server.Server{Handler: h}
+	go s.Serve(l)
+	t.Cleanup(func() { s.Close() })
+	return l.Addr().String()
+}
+
+// get sends a GET with the extra header lines and returns a reader positioned
+// at the start of the event stream.
+func get(t *testing.T, addr, header string) *bufio.Reader {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	io.WriteString(conn, "GET /events HTTP/1.1\r\nHost: a\r\n"+header+"\r\n")
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type = %q, want text/event-stream", ct)
+	}
+	return bufio.NewReader(resp.Body)
+}
+
+// readEvent reads up to and including the blank line ending the next event.
+func readEvent(t *testing.T, br *bufio.Reader) string {
+	var b strings.Builder
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading event: %v", err)
+		}
+		b.WriteString(line)
+		if line == "\n" {
+			return b.String()
+		}
+	}
+}
+
+func TestStream(t *testing.T) {
+	next := make(chan struct{})
+	addr := startServer(t, func(w http.ResponseWriter, r *http.Request) {
+		s, err := NewStream(w, r)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer s.Close()
+
+		s.Retry(3 * time.Second)
+		s.Send("", "", "hello")
+		// Each event must reach the client before the handler goes on.
+		<-next
+		s.Send("update", "42", "line one\nline two")
+		s.Comment("still here")
+		if err := s.Send("bad\nname", "", "x"); err == nil {
+			t.Error("Send accepted an event name with a newline")
+		}
+	})
+
+	br := get(t, addr, "")
+	for _, want := range []string{
+		"retry: 3000\n\n",
+		"data: hello\n\n",
+	} {
+		if got := readEvent(t, br); got != want {
+			t.Errorf("event = %q, want %q", got, want)
+		}
+	}
+	close(next)
+	for _, want := range []string{
+		"event: update\nid: 42\ndata: line one\ndata: line two\n\n",
+		": still here\n\n",
+	} {
+		if got := readEvent(t, br); got != want {
+			t.Errorf("event = %q, want %q", got, want)
+		}
+	}
+}
+
+// TestStreamBareCR checks that a CR, which ends a line in an event stream,
+// can't smuggle fields into an event.
+func TestStreamBareCR(t *testing.T) {
+	addr := startServer(t, func(w http.ResponseWriter, r *http.Request) {
+		s, err := NewStream(w, r)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer s.Close()
+		s.Send("", "", "x\rid: evil\revent: pwn")
+		s.Comment("a\rb\r\nc")
+	})
+
+	br := get(t, addr, "")
+	for _, want := range []string{
+		"data: x\ndata: id: evil\ndata: event: pwn\n\n",
+		": a\n: b\n: c\n\n",
+	} {
+		if got := readEvent(t, br); got != want {
+			t.Errorf("event = %q, want %q", got, want)
+		}
+	}
+}
+
+func TestStreamLastEventID(t *testing.T) {
+	addr := startServer(t, func(w http.ResponseWriter, r *http.Request) {
+		s, err := NewStream(w, r)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer s.Close()
+		s.Send("resume", "", "after "+s.LastEventID())
+	})
+
+	br := get(t, addr, "Last-Event-ID: 41\r\n")
+	if got, want := readEvent(t, br), "event: resume\ndata: after 41\n\n"; got != want {
+		t.Errorf("event = %q, want %q", got, want)
+	}
+}
+
+func TestStreamHeartbeat(t *testing.T) {
+	addr := startServer(t, func(w http.ResponseWriter, r *http.Request) {
+		s, err := NewStream(w, r)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		s.Heartbeat(10 * time.Millisecond)
+		time.Sleep(100 * time.Millisecond)
+		s.Close()
+		if err := s.Send("", "", "late"); err != ErrClosed {
+			t.Errorf("Send after Close = %v, want ErrClosed", err)
+		}
+	})
+
+	br := get(t, addr, "")
+	for i := 0; i < 2; i++ {
+		if got := readEvent(t, br); got != ":\n\n" {
+			t.Errorf("heartbeat = %q, want %q", got, ":\n\n")
+		}
+	}
+}
+
+func TestStreamHeartbeatDisabled(t *testing.T) {
+	addr := startServer(t, func(w http.ResponseWriter, r *http.Request) {
+		s, err := NewStream(w, r)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer s.Close()
+		s.Heartbeat(0)
+		s.Heartbeat(-time.Second)
+		time.Sleep(50 * time.Millisecond)
+		s.Send("", "", "no heartbeat")
+	})
+
+	br := get(t, addr, "")
+	if got, want := readEvent(t, br), "data: no heartbeat\n\n"; got != want {
+		t.Errorf("event = %q, want %q", got, want)
+	}
+}
+
+func TestStreamClientDisconnect(t *testing.T) {
+	done := make(chan struct{})
+	addr := startServer(t, func(w http.ResponseWriter, r *http.Request) {
+		defer close(done)
+		s, err := NewStream(w, r)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer s.Close()
+		s.Heartbeat(10 * time.Millisecond)
+		select {
+		case <-s.Done():
+		case <-time.After(5 * time.Second):
+			t.Error("stream not done after the client went away")
+		}
+	})
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(conn, "GET /events HTTP/1.1\r\nHost: a\r\n\r\n")
+	if _, err := http.ReadResponse(bufio.NewReader(conn), nil); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	<-done
+}
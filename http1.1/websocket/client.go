@@ -0,0 +1,141 @@
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/kianooshaz/http-from-scratch/http1.1/client"
+)
+
+// ErrBadHandshake is returned by Dial when the server doesn't accept the
+// opening handshake. The response, if any, is returned along with it.
+var ErrBadHandshake = errors.New("websocket: bad handshake")
+
+// Dialer opens client connections. The zero value is ready to use.
+type Dialer struct {
+	// NetDialContext opens the TCP connection. If nil, net.Dialer is used.
+	NetDialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// MaxMessageSize caps the size of a message read from the server. If
+	// zero, DefaultMaxMessageSize is used.
+	MaxMessageSize int64
+
+	// Subprotocols are offered to the server in order of preference.
+	Subprotocols []string
+}
+
+// DefaultDialer is used by Dial.
+var DefaultDialer = &Dialer{}
+
+// Dial connects to a ws:// URL with DefaultDialer.
+func Dial(ctx context.Context, urlStr string, header http.Header) (*Conn, *http.Response, error) {
+	return DefaultDialer.Dial(ctx, urlStr, header)
+}
+
+// Dial connects to a ws:// URL and performs the opening handshake. header
+// holds extra request headers, e.g. Origin or cookies. The handshake
+// response is returned even on failure, if the server sent one; its body is
+// already closed.
+func (d *Dialer) Dial(ctx context.Context, urlStr string, header http.Header) (*Conn, *http.Response, error) {
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch u.Scheme {
+	case "ws":
+		u.Scheme = "http"
+	default:
+		return nil, nil, fmt.Errorf("websocket: unsupported scheme %q", u.Scheme)
+	}
+
+	var keyBytes [16]byte
+	if _, err := rand.Read(keyBytes[:]); err != nil {
+		return nil, nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(keyBytes[:])
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	for k, vals := range header {
+		req.Header[k] = vals
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	if len(d.Subprotocols) > 0 {
+		req.Header.Set("Sec-WebSocket-Protocol", strings.Join(d.Subprotocols, ", "))
+	}
+
+	// The client hides the connection, note it down as it is dialed. Pooling
+	// is off, every handshake gets a connection of its own.
+	var netConn net.Conn
+	dial := d.NetDialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	c := &client.Client{
+		MaxIdleConnsPerHost: -1,
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := dial(ctx, network, addr)
+			netConn = conn
+			return conn, err
+		},
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := d.checkHandshake(resp, key); err != nil {
+		resp.Body.Close()
+		return nil, resp, err
+	}
+	body, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok || netConn == nil {
+		resp.Body.Close()
+		return nil, resp, ErrBadHandshake
+	}
+
+	// The server may have sent frames right after the 101, those are
+	// buffered in the body already. Writes go straight to the connection.
+	conn := newConn(netConn, bufio.NewReader(body), bufio.NewWriter(netConn), true, d.MaxMessageSize)
+	conn.subprotocol = resp.Header.Get("Sec-WebSocket-Protocol")
+	return conn, resp, nil
+}
+
+// checkHandshake validates the server's answer to the opening handshake,
+// see RFC 6455 section 4.1.
+func (d *Dialer) checkHandshake(resp *http.Response, key string) error {
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return ErrBadHandshake
+	}
+	if !headerValuesContainsToken(resp.Header["Upgrade"], "websocket") ||
+		!headerValuesContainsToken(resp.Header["Connection"], "upgrade") {
+		return ErrBadHandshake
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		return fmt.Errorf("%w: invalid Sec-WebSocket-Accept", ErrBadHandshake)
+	}
+	if p := resp.Header.Get("Sec-WebSocket-Protocol"); p != "" {
+		offered := false
+		for _, s := range d.Subprotocols {
+			offered = offered || s == p
+		}
+		if !offered {
+			return fmt.Errorf("%w: server picked subprotocol %q we didn't offer", ErrBadHandshake, p)
+		}
+	}
+	return nil
+}
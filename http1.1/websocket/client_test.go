@@ -0,0 +1,181 @@
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func dial(t *testing.T, d *Dialer, addr string) *Conn {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, resp, err := d.Dial(ctx, "ws://"+addr+"/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusSwitchingProtocols)
+	}
+	t.Cleanup(func() { c.Close() })
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	return c
+}
+
+func TestDialEcho(t *testing.T) {
+	addr, done := echoServer(t, &Upgrader{Subprotocols: []string{"chat"}})
+	c := dial(t, &Dialer{Subprotocols: []string{"superchat", "chat"}}, addr)
+
+	if c.Subprotocol() != "chat" {
+		t.Errorf("Subprotocol = %q, want %q", c.Subprotocol(), "chat")
+	}
+	for _, msg := range []string{"hello", "world"} {
+		if err := c.WriteMessage(TextMessage, []byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+		typ, got, err := c.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if typ != TextMessage || string(got) != msg {
+			t.Errorf("echo = type %d %q, want text %q", typ, got, msg)
+		}
+	}
+
+	// A clean close: we start it, the server echoes it.
+	c.WriteClose(CloseNormalClosure, "done")
+	var closeErr *CloseError
+	if _, _, err := c.ReadMessage(); !errors.As(err, &closeErr) || closeErr.Code != CloseNormalClosure {
+		t.Errorf("client read error = %v, want close %d", err, CloseNormalClosure)
+	}
+	if err := <-done; !errors.As(err, &closeErr) || closeErr.Text != "done" {
+		t.Errorf("server read error = %v, want close %q", err, "done")
+	}
+}
+
+func TestDialServerClose(t *testing.T) {
+	serverErr := make(chan error, 1)
+	addr := startServer(t, func(w http.ResponseWriter, r *http.Request) {
+		c, err := (&Upgrader{}).Upgrade(w, r)
+		if err != nil {
+			serverErr <- err
+			return
+		}
+		defer c.Close()
+		c.WriteClose(CloseGoingAway, "restarting")
+		_, _, err = c.ReadMessage()
+		serverErr <- err
+	})
+	c := dial(t, &Dialer{}, addr)
+
+	var closeErr *CloseError
+	if _, _, err := c.ReadMessage(); !errors.As(err, &closeErr) || closeErr.Code != CloseGoingAway || closeErr.Text != "restarting" {
+		t.Errorf("client read error = %v, want close %d %q", err, CloseGoingAway, "restarting")
+	}
+	if err := <-serverErr; !errors.As(err, &closeErr) || closeErr.Code != CloseGoingAway {
+		t.Errorf("server read error = %v, want the echoed close %d", err, CloseGoingAway)
+	}
+}
+
+func TestDialAnswersPing(t *testing.T) {
+	pong := make(chan frameHeader, 1)
+	payload := make(chan string, 1)
+	addr := startServer(t, func(w http.ResponseWriter, r *http.Request) {
+		// A hand-rolled server, to see the client's frames as they are.
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+			"Sec-WebSocket-Accept: " + acceptKey(r.Header.Get("Sec-WebSocket-Key")) + "\r\n\r\n")
+		writeFrame(rw.Writer, true, opPing, nil, []byte("ping"))
+		writeFrame(rw.Writer, true, opText, nil, []byte("after ping"))
+		rw.Flush()
+
+		h, err := readFrameHeader(rw.Reader)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		p, _ := readPayload(rw.Reader, h)
+		pong <- h
+		payload <- string(p)
+	})
+	c := dial(t, &Dialer{}, addr)
+
+	typ, msg, err := c.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ != TextMessage || string(msg) != "after ping" {
+		t.Errorf("message = type %d %q, want text %q", typ, msg, "after ping")
+	}
+	if h := <-pong; h.opcode != opPong || !h.masked {
+		t.Errorf("answer to ping: opcode %x masked %v, want a masked pong", h.opcode, h.masked)
+	}
+	if p := <-payload; p != "ping" {
+		t.Errorf("pong payload = %q, want %q", p, "ping")
+	}
+}
+
+func TestDialBadHandshake(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		status  int
+	}{
+		{
+			name: "rejected",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "go away", http.StatusForbidden)
+			},
+			status: http.StatusForbidden,
+		},
+		{
+			name: "wrong accept",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				conn, rw, _ := w.(http.Hijacker).Hijack()
+				defer conn.Close()
+				rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+					"Sec-WebSocket-Accept: " + acceptKey("not the key") + "\r\n\r\n")
+				rw.Flush()
+				bufio.NewReader(conn).ReadByte()
+			},
+			status: http.StatusSwitchingProtocols,
+		},
+		{
+			name: "unoffered subprotocol",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				conn, rw, _ := w.(http.Hijacker).Hijack()
+				defer conn.Close()
+				rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+					"Sec-WebSocket-Accept: " + acceptKey(r.Header.Get("Sec-WebSocket-Key")) + "\r\n" +
+					"Sec-WebSocket-Protocol: mqtt\r\n\r\n")
+				rw.Flush()
+				bufio.NewReader(conn).ReadByte()
+			},
+			status: http.StatusSwitchingProtocols,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr := startServer(t, tt.handler)
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			_, resp, err := Dial(ctx, "ws://"+addr+"/ws", nil)
+			if !errors.Is(err, ErrBadHandshake) {
+				t.Fatalf("Dial error = %v, want %v", err, ErrBadHandshake)
+			}
+			if resp == nil || resp.StatusCode != tt.status {
+				t.Errorf("response = %v, want status %d", resp, tt.status)
+			}
+		})
+	}
+}
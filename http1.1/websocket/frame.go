@@ -0,0 +1,165 @@
+package websocket
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+)
+
+// Opcodes, see RFC 6455 section 5.2.
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+const (
+	finBit  = 1 << 7
+	rsvBits = 0x70
+	maskBit = 1 << 7
+
+	// maxControlPayload is the largest payload of a close, ping or pong.
+	maxControlPayload = 125
+)
+
+func isControl(opcode byte) bool { return opcode&0x8 != 0 }
+
+// frameHeader is the part of a frame before its payload.
+type frameHeader struct {
+	fin     bool
+	opcode  byte
+	masked  bool
+	maskKey [4]byte
+	length  int64
+}
+
+// readFrameHeader reads and checks the header of the next frame. Protocol
+// violations are reported as a *failure with the code to fail the
+// connection with, read errors as they are.
+func readFrameHeader(br *bufio.Reader) (frameHeader, error) {
+	var h frameHeader
+	var b [8]byte
+	if _, err := io.ReadFull(br, b[:2]); err != nil {
+		return h, err
+	}
+
+	h.fin = b[0]&finBit != 0
+	h.opcode = b[0] & 0xf
+	h.masked = b[1]&maskBit != 0
+	if b[0]&rsvBits != 0 {
+		return h, protocolError("reserved bits set")
+	}
+	switch h.opcode {
+	case opContinuation, opText, opBinary, opClose, opPing, opPong:
+	default:
+		return h, protocolError("unknown opcode")
+	}
+
+	// The 7 bit length, or 126 for a 16 bit one, or 127 for a 64 bit one.
+	switch n := int64(b[1] &^ maskBit); n {
+	case 126:
+		if _, err := io.ReadFull(br, b[:2]); err != nil {
+			return h, unexpectedEOF(err)
+		}
+		h.length = int64(binary.BigEndian.Uint16(b[:2]))
+	case 127:
+		if _, err := io.ReadFull(br, b[:8]); err != nil {
+			return h, unexpectedEOF(err)
+		}
+		// The most significant bit must be 0.
+		if b[0]&0x80 != 0 {
+			return h, protocolError("invalid payload length")
+		}
+		h.length = int64(binary.BigEndian.Uint64(b[:8]))
+	default:
+		h.length = n
+	}
+
+	if isControl(h.opcode) {
+		if !h.fin {
+			return h, protocolError("fragmented control frame")
+		}
+		if h.length > maxControlPayload {
+			return h, protocolError("control frame too long")
+		}
+	}
+
+	if h.masked {
+		if _, err := io.ReadFull(br, h.maskKey[:]); err != nil {
+			return h, unexpectedEOF(err)
+		}
+	}
+	return h, nil
+}
+
+// readPayload reads the payload of a frame and unmasks it.
+func readPayload(br *bufio.Reader, h frameHeader) ([]byte, error) {
+	p := make([]byte, h.length)
+	if _, err := io.ReadFull(br, p); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if h.masked {
+		maskBytes(h.maskKey, p)
+	}
+	return p, nil
+}
+
+// writeFrame writes a whole frame to bw, masking the payload with maskKey
+// if it is not nil. It doesn't flush.
+func writeFrame(bw *bufio.Writer, fin bool, opcode byte, maskKey *[4]byte, payload []byte) error {
+	var b [14]byte
+	b[0] = opcode
+	if fin {
+		b[0] |= finBit
+	}
+
+	n := 2
+	switch l := len(payload); {
+	case l <= 125:
+		b[1] = byte(l)
+	case l <= 0xffff:
+		b[1] = 126
+		binary.BigEndian.PutUint16(b[2:], uint16(l))
+		n += 2
+	default:
+		b[1] = 127
+		binary.BigEndian.PutUint64(b[2:], uint64(l))
+		n += 8
+	}
+
+	if maskKey != nil {
+		b[1] |= maskBit
+		n += copy(b[n:], maskKey[:])
+	}
+	if _, err := bw.Write(b[:n]); err != nil {
+		return err
+	}
+
+	if maskKey == nil {
+		_, err := bw.Write(payload)
+		return err
+	}
+	// Mask a copy, the caller still owns payload.
+	masked := make([]byte, len(payload))
+	copy(masked, payload)
+	maskBytes(*maskKey, masked)
+	_, err := bw.Write(masked)
+	return err
+}
+
+// maskBytes masks or unmasks p in place, see RFC 6455 section 5.3.
+func maskBytes(key [4]byte, p []byte) {
+	for i := range p {
+		p[i] ^= key[i&3]
+	}
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
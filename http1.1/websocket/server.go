@@ -0,0 +1,150 @@
+package websocket
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// acceptGUID is appended to the client's key to compute the accept value,
+// see RFC 6455 section 1.3.
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// Upgrader turns HTTP requests into WebSocket connections.
+type Upgrader struct {
+	// MaxMessageSize caps the size of a message read from the client. A
+	// longer one fails the connection with CloseMessageTooBig. If zero,
+	// DefaultMaxMessageSize is used.
+	MaxMessageSize int64
+
+	// Subprotocols are the subprotocols the server speaks. The first one
+	// the client offers that is in the list is picked.
+	Subprotocols []string
+
+	// CheckOrigin reports whether a request's Origin is allowed. If nil,
+	// browsers may only connect from a page of the same host.
+	CheckOrigin func(r *http.Request) bool
+}
+
+// Upgrade performs the opening handshake. If the request isn't a valid
+// WebSocket handshake it answers with an HTTP error and returns an error.
+func (u *Upgrader) Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	if r.Method != http.MethodGet {
+		return nil, u.reject(w, http.StatusMethodNotAllowed, "request method is not GET")
+	}
+	if !r.ProtoAtLeast(1, 1) {
+		return nil, u.reject(w, http.StatusBadRequest, "request is not HTTP/1.1")
+	}
+	if !headerValuesContainsToken(r.Header["Connection"], "upgrade") {
+		return nil, u.reject(w, http.StatusBadRequest, "missing Connection: Upgrade")
+	}
+	if !headerValuesContainsToken(r.Header["Upgrade"], "websocket") {
+		return nil, u.reject(w, http.StatusBadRequest, "missing Upgrade: websocket")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		// Tell the client which version we speak.
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		return nil, u.reject(w, http.StatusUpgradeRequired, "unsupported Sec-WebSocket-Version")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		return nil, u.reject(w, http.StatusBadRequest, "invalid Sec-WebSocket-Key")
+	}
+	checkOrigin := u.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = sameOrigin
+	}
+	if !checkOrigin(r) {
+		return nil, u.reject(w, http.StatusForbidden, "origin not allowed")
+	}
+
+	h, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, u.reject(w, http.StatusInternalServerError, "response writer can't be hijacked")
+	}
+	netConn, rw, err := h.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	// The server's deadlines were meant for the HTTP exchange.
+	netConn.SetDeadline(time.Time{})
+
+	subprotocol := u.selectSubprotocol(r)
+	resp := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n"
+	if subprotocol != "" {
+		resp += "Sec-WebSocket-Protocol: " + subprotocol + "\r\n"
+	}
+	resp += "\r\n"
+	if _, err := rw.WriteString(resp); err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	if err := rw.Flush(); err != nil {
+		netConn.Close()
+		return nil, err
+	}
+
+	c := newConn(netConn, rw.Reader, rw.Writer, false, u.MaxMessageSize)
+	c.subprotocol = subprotocol
+	return c, nil
+}
+
+func (u *Upgrader) reject(w http.ResponseWriter, status int, text string) error {
+	http.Error(w, text, status)
+	return errors.New("websocket: " + text)
+}
+
+func (u *Upgrader) selectSubprotocol(r *http.Request) string {
+	for _, offered := range r.Header["Sec-Websocket-Protocol"] {
+		for _, p := range strings.Split(offered, ",") {
+			p = strings.TrimSpace(p)
+			for _, supported := range u.Subprotocols {
+				if p == supported {
+					return p
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// sameOrigin allows requests without an Origin, which don't come from a
+// browser, and those from a page of the host they connect to.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// acceptKey computes the Sec-WebSocket-Accept value for a client's key.
+func acceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// headerValuesContainsToken reports whether any of the comma separated
+// values contains token, ignoring case.
+func headerValuesContainsToken(values []string, token string) bool {
+	for _, v := range values {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
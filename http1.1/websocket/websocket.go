@@ -0,0 +1,349 @@
+// Package websocket implements the WebSocket protocol of RFC 6455. Upgrader
+// takes over connections hijacked from the http1.1 server, or any other
+// server whose ResponseWriter implements http.Hijacker. Dial is the client
+// side, it sends the handshake with the http1.1 client.
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// DefaultMaxMessageSize is used when no MaxMessageSize is configured.
+const DefaultMaxMessageSize = 1 << 20
+
+// MessageType is the type of a data message.
+type MessageType int
+
+const (
+	TextMessage   MessageType = opText
+	BinaryMessage MessageType = opBinary
+)
+
+// Close codes, see RFC 6455 section 7.4.1.
+const (
+	CloseNormalClosure           = 1000
+	CloseGoingAway               = 1001
+	CloseProtocolError           = 1002
+	CloseUnsupportedData         = 1003
+	CloseNoStatusReceived        = 1005
+	CloseInvalidFramePayloadData = 1007
+	ClosePolicyViolation         = 1008
+	CloseMessageTooBig           = 1009
+	CloseInternalServerErr       = 1011
+)
+
+var (
+	// ErrMessageTooBig is returned by ReadMessage for a message longer
+	// than the connection's maximum message size.
+	ErrMessageTooBig = errors.New("websocket: message too big")
+
+	// ErrCloseSent is returned when writing after a close frame was sent.
+	ErrCloseSent = errors.New("websocket: close sent")
+)
+
+// CloseError is returned by ReadMessage once the peer has closed the
+// connection. Code is CloseNoStatusReceived if the peer didn't send one.
+type CloseError struct {
+	Code int
+	Text string
+}
+
+func (e *CloseError) Error() string {
+	if e.Text == "" {
+		return "websocket: close " + strconv.Itoa(e.Code)
+	}
+	return "websocket: close " + strconv.Itoa(e.Code) + ": " + e.Text
+}
+
+// failure is a violation of the protocol by the peer. The connection is
+// failed with code.
+type failure struct {
+	code int
+	text string
+}
+
+func (f *failure) Error() string { return "websocket: " + f.text }
+
+func protocolError(text string) error {
+	return &failure{code: CloseProtocolError, text: text}
+}
+
+// Conn is a WebSocket connection. One goroutine may read while another one
+// writes. Pings are answered and pongs discarded as part of ReadMessage, so
+// keep reading to keep the connection healthy.
+type Conn struct {
+	conn           net.Conn
+	br             *bufio.Reader
+	isClient       bool
+	subprotocol    string
+	maxMessageSize int64
+
+	readErr error // sticky, once reading failed it keeps failing
+
+	writeMu   sync.Mutex
+	bw        *bufio.Writer
+	closeSent bool
+}
+
+func newConn(conn net.Conn, br *bufio.Reader, bw *bufio.Writer, isClient bool, maxMessageSize int64) *Conn {
+	if maxMessageSize <= 0 {
+		maxMessageSize = DefaultMaxMessageSize
+	}
+	return &Conn{
+		conn:           conn,
+		br:             br,
+		bw:             bw,
+		isClient:       isClient,
+		maxMessageSize: maxMessageSize,
+	}
+}
+
+// Subprotocol is the subprotocol agreed on in the handshake, if any.
+func (c *Conn) Subprotocol() string { return c.subprotocol }
+
+func (c *Conn) LocalAddr() net.Addr                { return c.conn.LocalAddr() }
+func (c *Conn) RemoteAddr() net.Addr               { return c.conn.RemoteAddr() }
+func (c *Conn) SetReadDeadline(t time.Time) error  { return c.conn.SetReadDeadline(t) }
+func (c *Conn) SetWriteDeadline(t time.Time) error { return c.conn.SetWriteDeadline(t) }
+
+// ReadMessage reads the next data message, putting fragments back together.
+// Once the peer closes the connection it returns a *CloseError, the close
+// frame has then been answered already.
+func (c *Conn) ReadMessage() (MessageType, []byte, error) {
+	if c.readErr != nil {
+		return 0, nil, c.readErr
+	}
+	typ, msg, err := c.readMessage()
+	if err != nil {
+		c.readErr = err
+		return 0, nil, err
+	}
+	return typ, msg, nil
+}
+
+func (c *Conn) readMessage() (MessageType, []byte, error) {
+	var typ MessageType
+	var msg []byte
+	for {
+		h, err := readFrameHeader(c.br)
+		if err != nil {
+			return 0, nil, c.fail(err)
+		}
+		// Clients mask every frame, servers none.
+		if h.masked == c.isClient {
+			if c.isClient {
+				return 0, nil, c.fail(protocolError("masked frame from server"))
+			}
+			return 0, nil, c.fail(protocolError("unmasked frame from client"))
+		}
+
+		if !isControl(h.opcode) {
+			switch {
+			case h.opcode == opContinuation && typ == 0:
+				return 0, nil, c.fail(protocolError("continuation frame without a message"))
+			case h.opcode != opContinuation && typ != 0:
+				return 0, nil, c.fail(protocolError("new message inside a fragmented one"))
+			}
+			if int64(len(msg))+h.length > c.maxMessageSize {
+				c.fail(&failure{code: CloseMessageTooBig, text: "message too big"})
+				return 0, nil, ErrMessageTooBig
+			}
+		}
+
+		p, err := readPayload(c.br, h)
+		if err != nil {
+			return 0, nil, c.fail(err)
+		}
+
+		switch h.opcode {
+		case opPing:
+			if err := c.writeControl(opPong, p); err != nil && err != ErrCloseSent {
+				return 0, nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			return 0, nil, c.handleClose(p)
+		case opText, opBinary:
+			typ = MessageType(h.opcode)
+		}
+
+		msg = append(msg, p...)
+		if !h.fin {
+			continue
+		}
+		if typ == TextMessage && !utf8.Valid(msg) {
+			return 0, nil, c.fail(&failure{code: CloseInvalidFramePayloadData, text: "invalid UTF-8 in text message"})
+		}
+		if msg == nil {
+			msg = []byte{}
+		}
+		return typ, msg, nil
+	}
+}
+
+// handleClose answers the peer's close frame and returns it as an error.
+func (c *Conn) handleClose(p []byte) error {
+	closeErr := &CloseError{Code: CloseNoStatusReceived}
+	switch {
+	case len(p) == 1:
+		return c.fail(protocolError("invalid close frame"))
+	case len(p) >= 2:
+		closeErr.Code = int(binary.BigEndian.Uint16(p))
+		closeErr.Text = string(p[2:])
+		if !validCloseCode(closeErr.Code) {
+			return c.fail(protocolError("invalid close code"))
+		}
+		if !utf8.Valid(p[2:]) {
+			return c.fail(&failure{code: CloseInvalidFramePayloadData, text: "invalid UTF-8 in close reason"})
+		}
+		p = p[:2]
+	}
+
+	// Echo the code to complete the closing handshake.
+	if err := c.writeControl(opClose, p); err != nil && err != ErrCloseSent {
+		return err
+	}
+	return closeErr
+}
+
+// fail sends a close frame for a protocol violation. Other errors, like the
+// connection going away, are returned as is.
+func (c *Conn) fail(err error) error {
+	var f *failure
+	if errors.As(err, &f) {
+		c.WriteClose(f.code, f.text)
+	}
+	return err
+}
+
+// validCloseCode reports whether code may be sent in a close frame.
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1011:
+		return true
+	case code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
+
+// WriteMessage sends data as a single frame.
+func (c *Conn) WriteMessage(typ MessageType, data []byte) error {
+	if typ != TextMessage && typ != BinaryMessage {
+		return fmt.Errorf("websocket: unknown message type %d", typ)
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.writeFrameLocked(true, byte(typ), data)
+}
+
+// NextWriter returns a writer for a message sent in fragments: every Write
+// goes out as a frame of its own, Close ends the message. Don't send other
+// messages before the writer is closed.
+func (c *Conn) NextWriter(typ MessageType) (io.WriteCloser, error) {
+	if typ != TextMessage && typ != BinaryMessage {
+		return nil, fmt.Errorf("websocket: unknown message type %d", typ)
+	}
+	return &messageWriter{c: c, opcode: byte(typ)}, nil
+}
+
+type messageWriter struct {
+	c      *Conn
+	opcode byte // the message type for the first frame, then continuation
+	closed bool
+}
+
+func (w *messageWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("websocket: write to closed message writer")
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if err := w.writeFrame(false, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (w *messageWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return w.writeFrame(true, nil)
+}
+
+func (w *messageWriter) writeFrame(fin bool, p []byte) error {
+	w.c.writeMu.Lock()
+	defer w.c.writeMu.Unlock()
+	err := w.c.writeFrameLocked(fin, w.opcode, p)
+	w.opcode = opContinuation
+	return err
+}
+
+// Ping sends a ping with up to 125 bytes of data. The peer's pong is
+// consumed by ReadMessage.
+func (c *Conn) Ping(data []byte) error {
+	return c.writeControl(opPing, data)
+}
+
+// WriteClose starts the closing handshake. Keep reading until ReadMessage
+// returns the peer's *CloseError, then Close the connection.
+func (c *Conn) WriteClose(code int, text string) error {
+	p := make([]byte, 2, 2+len(text))
+	binary.BigEndian.PutUint16(p, uint16(code))
+	p = append(p, text...)
+	return c.writeControl(opClose, p)
+}
+
+// Close sends a normal close frame, unless a close frame was sent already,
+// and closes the underlying connection without waiting for the peer.
+func (c *Conn) Close() error {
+	c.WriteClose(CloseNormalClosure, "")
+	return c.conn.Close()
+}
+
+func (c *Conn) writeControl(opcode byte, p []byte) error {
+	if len(p) > maxControlPayload {
+		return errors.New("websocket: control frame payload too long")
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.writeFrameLocked(true, opcode, p)
+}
+
+// writeFrameLocked writes and flushes one frame. Nothing may follow a close
+// frame.
+func (c *Conn) writeFrameLocked(fin bool, opcode byte, p []byte) error {
+	if c.closeSent {
+		return ErrCloseSent
+	}
+	if opcode == opClose {
+		c.closeSent = true
+	}
+
+	var maskKey *[4]byte
+	if c.isClient {
+		maskKey = new([4]byte)
+		if _, err := rand.Read(maskKey[:]); err != nil {
+			return err
+		}
+	}
+	if err := writeFrame(c.bw, fin, opcode, maskKey, p); err != nil {
+		return err
+	}
+	return c.bw.Flush()
+}
@@ -0,0 +1,348 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kianooshaz/http-from-scratch/http1.1/server"
+)
+
+// startServer serves h with the http1.1 server on a loopback port.
+func startServer(t *testing.T, h http.HandlerFunc) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &server.Server{Handler: h}
+	go s.Serve(l)
+	t.Cleanup(func() { s.Close() })
+	return l.Addr().String()
+}
+
+// echoServer echoes every message until the client closes. The final error
+// of the first connection is sent on done.
+func echoServer(t *testing.T, u *Upgrader) (addr string, done <-chan error) {
+	errc := make(chan error, 1)
+	report := func(err error) {
+		select {
+		case errc <- err:
+		default:
+		}
+	}
+	addr = startServer(t, func(w http.ResponseWriter, r *http.Request) {
+		c, err := u.Upgrade(w, r)
+		if err != nil {
+			report(err)
+			return
+		}
+		defer c.Close()
+		for {
+			typ, msg, err := c.ReadMessage()
+			if err != nil {
+				report(err)
+				return
+			}
+			if err := c.WriteMessage(typ, msg); err != nil {
+				report(err)
+				return
+			}
+		}
+	})
+	return addr, errc
+}
+
+const testKey = "dGhlIHNhbXBsZSBub25jZQ=="
+
+// handshake sends an opening handshake with the extra header lines and
+// returns the response and the connection, ready for frames.
+func handshake(t *testing.T, addr, header string) (*http.Response, net.Conn, *bufio.Reader) {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	io.WriteString(conn, "GET /ws HTTP/1.1\r\nHost: "+addr+"\r\n"+header+"\r\n")
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, conn, br
+}
+
+// dialTest connects a client side Conn to addr.
+func dialTest(t *testing.T, addr string) *Conn {
+	t.Helper()
+
+	resp, conn, br := handshake(t, addr, "Connection: Upgrade\r\nUpgrade: websocket\r\n"+
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: "+testKey+"\r\n")
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusSwitchingProtocols)
+	}
+	return newConn(conn, br, bufio.NewWriter(conn), true, 0)
+}
+
+func TestUpgradeHandshake(t *testing.T) {
+	addr, _ := echoServer(t, &Upgrader{Subprotocols: []string{"chat"}})
+
+	resp, _, _ := handshake(t, addr, "Connection: keep-alive, Upgrade\r\nUpgrade: websocket\r\n"+
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: "+testKey+"\r\n"+
+		"Sec-WebSocket-Protocol: superchat, chat\r\nOrigin: http://"+addr+"\r\n")
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusSwitchingProtocols)
+	}
+	// The example of RFC 6455 section 1.3.
+	if got, want := resp.Header.Get("Sec-WebSocket-Accept"), "s3pPLMBiTxaQ9kYGzzhZRbK+xOo="; got != want {
+		t.Errorf("Sec-WebSocket-Accept = %q, want %q", got, want)
+	}
+	if got := resp.Header.Get("Sec-WebSocket-Protocol"); got != "chat" {
+		t.Errorf("Sec-WebSocket-Protocol = %q, want %q", got, "chat")
+	}
+}
+
+func TestUpgradeRejects(t *testing.T) {
+	addr, _ := echoServer(t, &Upgrader{})
+
+	valid := map[string]string{
+		"Connection":            "Upgrade",
+		"Upgrade":               "websocket",
+		"Sec-WebSocket-Version": "13",
+		"Sec-WebSocket-Key":     testKey,
+	}
+	tests := []struct {
+		name   string
+		header map[string]string // overrides, "" drops the header
+		status int
+	}{
+		{"no upgrade", map[string]string{"Upgrade": ""}, http.StatusBadRequest},
+		{"no connection upgrade", map[string]string{"Connection": "keep-alive"}, http.StatusBadRequest},
+		{"old version", map[string]string{"Sec-WebSocket-Version": "8"}, http.StatusUpgradeRequired},
+		{"short key", map[string]string{"Sec-WebSocket-Key": "c2hvcnQ="}, http.StatusBadRequest},
+		{"cross origin", map[string]string{"Origin": "http://evil.example"}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var header strings.Builder
+			for k, v := range valid {
+				if o, ok := tt.header[k]; ok {
+					v = o
+				}
+				if v != "" {
+					header.WriteString(k + ": " + v + "\r\n")
+				}
+			}
+			if o := tt.header["Origin"]; o != "" {
+				header.WriteString("Origin: " + o + "\r\n")
+			}
+
+			resp, _, _ := handshake(t, addr, header.String())
+			if resp.StatusCode != tt.status {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.status)
+			}
+		})
+	}
+}
+
+func TestEcho(t *testing.T) {
+	addr, _ := echoServer(t, &Upgrader{})
+	c := dialTest(t, addr)
+
+	tests := []struct {
+		typ  MessageType
+		data []byte
+	}{
+		{TextMessage, []byte("hello")},
+		{BinaryMessage, []byte{0, 1, 2, 0xff}},
+		{TextMessage, []byte{}},
+		// 16 and 64 bit payload lengths.
+		{BinaryMessage, bytes.Repeat([]byte("x"), 1000)},
+		{BinaryMessage, bytes.Repeat([]byte("y"), 70000)},
+	}
+	for _, tt := range tests {
+		if err := c.WriteMessage(tt.typ, tt.data); err != nil {
+			t.Fatal(err)
+		}
+		typ, msg, err := c.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if typ != tt.typ || !bytes.Equal(msg, tt.data) {
+			t.Errorf("echo of %d bytes = type %d, %d bytes", len(tt.data), typ, len(msg))
+		}
+	}
+}
+
+func TestFragmentedMessage(t *testing.T) {
+	addr, _ := echoServer(t, &Upgrader{})
+	c := dialTest(t, addr)
+
+	w, err := c.NextWriter(TextMessage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, "frag")
+	// Control frames may come between fragments.
+	if err := c.Ping([]byte("p")); err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, "mented")
+	w.Close()
+
+	typ, msg, err := c.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ != TextMessage || string(msg) != "fragmented" {
+		t.Errorf("got type %d %q, want text %q", typ, msg, "fragmented")
+	}
+}
+
+func TestPingPong(t *testing.T) {
+	addr, _ := echoServer(t, &Upgrader{})
+	_, conn, br := handshake(t, addr, "Connection: Upgrade\r\nUpgrade: websocket\r\n"+
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: "+testKey+"\r\n")
+
+	bw := bufio.NewWriter(conn)
+	writeFrame(bw, true, opPing, &[4]byte{1, 2, 3, 4}, []byte("are you there"))
+	bw.Flush()
+
+	h, err := readFrameHeader(br)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := readPayload(br, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.opcode != opPong || h.masked || string(p) != "are you there" {
+		t.Errorf("got opcode %x masked %v payload %q, want an unmasked pong echoing the ping", h.opcode, h.masked, p)
+	}
+}
+
+func TestCloseHandshake(t *testing.T) {
+	addr, done := echoServer(t, &Upgrader{})
+	c := dialTest(t, addr)
+
+	if err := c.WriteClose(CloseGoingAway, "bye"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.WriteMessage(TextMessage, []byte("late")); err != ErrCloseSent {
+		t.Errorf("write after close = %v, want %v", err, ErrCloseSent)
+	}
+
+	_, _, err := c.ReadMessage()
+	var closeErr *CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != CloseGoingAway {
+		t.Errorf("client read error = %v, want the echoed close %d", err, CloseGoingAway)
+	}
+	err = <-done
+	if !errors.As(err, &closeErr) || closeErr.Code != CloseGoingAway || closeErr.Text != "bye" {
+		t.Errorf("server read error = %v, want close %d %q", err, CloseGoingAway, "bye")
+	}
+}
+
+// expectClose reads frames until a close frame and returns its code.
+func expectClose(t *testing.T, br *bufio.Reader) int {
+	t.Helper()
+	for {
+		h, err := readFrameHeader(br)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := readPayload(br, h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h.opcode == opClose {
+			if len(p) < 2 {
+				t.Fatalf("close frame without code")
+			}
+			return int(p[0])<<8 | int(p[1])
+		}
+	}
+}
+
+func TestFailConnection(t *testing.T) {
+	mask := &[4]byte{9, 8, 7, 6}
+	tests := []struct {
+		name    string
+		frames  func(bw *bufio.Writer)
+		code    int
+		readErr error
+	}{
+		{
+			name:   "unmasked frame",
+			frames: func(bw *bufio.Writer) { writeFrame(bw, true, opText, nil, []byte("hi")) },
+			code:   CloseProtocolError,
+		},
+		{
+			name:   "invalid utf-8",
+			frames: func(bw *bufio.Writer) { writeFrame(bw, true, opText, mask, []byte{0xff, 0xfe}) },
+			code:   CloseInvalidFramePayloadData,
+		},
+		{
+			name:   "continuation without message",
+			frames: func(bw *bufio.Writer) { writeFrame(bw, true, opContinuation, mask, []byte("x")) },
+			code:   CloseProtocolError,
+		},
+		{
+			name: "interleaved messages",
+			frames: func(bw *bufio.Writer) {
+				writeFrame(bw, false, opText, mask, []byte("a"))
+				writeFrame(bw, true, opText, mask, []byte("b"))
+			},
+			code: CloseProtocolError,
+		},
+		{
+			name:   "fragmented ping",
+			frames: func(bw *bufio.Writer) { writeFrame(bw, false, opPing, mask, nil) },
+			code:   CloseProtocolError,
+		},
+		{
+			name:    "too big",
+			frames:  func(bw *bufio.Writer) { writeFrame(bw, true, opBinary, mask, make([]byte, 11)) },
+			code:    CloseMessageTooBig,
+			readErr: ErrMessageTooBig,
+		},
+		{
+			name: "too big in fragments",
+			frames: func(bw *bufio.Writer) {
+				writeFrame(bw, false, opBinary, mask, make([]byte, 6))
+				writeFrame(bw, true, opContinuation, mask, make([]byte, 6))
+			},
+			code:    CloseMessageTooBig,
+			readErr: ErrMessageTooBig,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, done := echoServer(t, &Upgrader{MaxMessageSize: 10})
+			_, conn, br := handshake(t, addr, "Connection: Upgrade\r\nUpgrade: websocket\r\n"+
+				"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: "+testKey+"\r\n")
+
+			bw := bufio.NewWriter(conn)
+			tt.frames(bw)
+			bw.Flush()
+
+			if code := expectClose(t, br); code != tt.code {
+				t.Errorf("close code = %d, want %d", code, tt.code)
+			}
+			if err := <-done; tt.readErr != nil && err != tt.readErr {
+				t.Errorf("server read error = %v, want %v", err, tt.readErr)
+			}
+		})
+	}
+}
@@ -2,20 +2,23 @@ package server
 
 import "io"
 
-type noBody struct{}
-
-func (noBody) Read([]byte) (int, error) { return 0, io.EOF }
-func (noBody) Close() error             { return nil }
-
+// bodyReader reads a body of n bytes. A connection that ends before that is
+// io.ErrUnexpectedEOF, not the end of the body.
 type bodyReader struct {
 	reader io.Reader
+	n      int64
 }
 
-func (r *bodyReader) Read(p []byte) (n int, err error) {
-	return r.reader.Read(p)
+func (r *bodyReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.n -= int64(n)
+	if err == io.EOF && r.n > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
 }
 
 func (r *bodyReader) Close() error {
-	_, err := io.Copy(io.Discard, r.reader)
+	_, err := io.Copy(io.Discard, r)
 	return err
 }
@@ -0,0 +1,15 @@
+package server
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestBodyReaderCutShort(t *testing.T) {
+	r := &bodyReader{reader: io.LimitReader(strings.NewReader("hel"), 5), n: 5}
+	body, err := io.ReadAll(r)
+	if string(body) != "hel" || err != io.ErrUnexpectedEOF {
+		t.Errorf("got %q, %v, want %q, %v", body, err, "hel", io.ErrUnexpectedEOF)
+	}
+}
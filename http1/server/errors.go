@@ -0,0 +1,18 @@
+package server
+
+import (
+	"io"
+
+	"github.com/kianooshaz/http-from-scratch/internal/httperr"
+)
+
+// RequestError reports a request rejected before the Handler, see httperr.RequestError.
+type RequestError = httperr.RequestError
+
+func badRequest(check string, err error) *RequestError {
+	return httperr.BadRequest(check, err)
+}
+
+func writeErrorResponse(w io.Writer, reqErr *RequestError) error {
+	return httperr.WriteResponse(w, "HTTP/1.0", reqErr)
+}
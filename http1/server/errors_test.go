@@ -0,0 +1,72 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMalformedRequestResponses(t *testing.T) {
+	addr := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("handler called for %s %s", r.Method, r.RequestURI)
+	}))
+
+	tests := []struct {
+		name       string
+		request    string
+		wantStatus int
+		wantCheck  string
+	}{
+		{"no spaces", "GET\r\n\r\n", http.StatusBadRequest, "invalid method"},
+		{"unknown method", "BREW / HTTP/1.0\r\n\r\n", http.StatusNotImplemented, "invalid method"},
+		{"bad uri", "GET foo HTTP/1.0\r\n\r\n", http.StatusBadRequest, "invalid path"},
+		{"double space", "GET  / HTTP/1.0\r\n\r\n", http.StatusBadRequest, "invalid path"},
+		{"unsupported version", "GET / HTTP/2.0\r\n\r\n", http.StatusHTTPVersionNotSupported, "invalid protocol"},
+		{"garbage version", "GET / SPDY\r\n\r\n", http.StatusBadRequest, "invalid protocol"},
+		{"lowercase version", "GET / http/1.0\r\n\r\n", http.StatusBadRequest, "invalid protocol"},
+		{"missing host", "GET / HTTP/1.1\r\n\r\n", http.StatusBadRequest, "missing Host header"},
+		{"two hosts", "GET / HTTP/1.0\r\nHost: a\r\nHost: b\r\n\r\n", http.StatusBadRequest, "too many Host headers"},
+		{"bad header", "GET / HTTP/1.0\r\nnocolon\r\n\r\n", http.StatusBadRequest, "invalid header"},
+		{"space before colon", "GET / HTTP/1.0\r\nHost : a\r\n\r\n", http.StatusBadRequest, "invalid header"},
+		{"obs-fold", "GET / HTTP/1.0\r\nX-A: b\r\n c\r\n\r\n", http.StatusBadRequest, "invalid header"},
+		{"control in value", "GET / HTTP/1.0\r\nX-A: b\x00c\r\n\r\n", http.StatusBadRequest, "invalid header"},
+		{"bare LF", "GET / HTTP/1.0\nX-A: b\r\n\r\n", http.StatusBadRequest, "invalid line"},
+		{"bare CR", "GET / HTTP/1.0\r\nX-A: b\rX-B: c\r\n\r\n", http.StatusBadRequest, "invalid line"},
+		{"bad content-length", "POST / HTTP/1.0\r\nContent-Length: five\r\n\r\n", http.StatusBadRequest, "invalid Content-Length"},
+		{"negative content-length", "POST / HTTP/1.0\r\nContent-Length: -1\r\n\r\n", http.StatusBadRequest, "invalid Content-Length"},
+		{"transfer-encoding", "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n", http.StatusNotImplemented, "unsupported Transfer-Encoding"},
+		{"huge headers", "GET / HTTP/1.0\r\nX-Big: " + strings.Repeat("a", DefaultMaxHeaderBytes) + "\r\n\r\n", http.StatusRequestHeaderFieldsTooLarge, "headers too large"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			conn, err := net.Dial("tcp", addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+			go io.WriteString(conn, tt.request)
+
+			resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if !strings.HasSuffix(string(body), tt.wantCheck) {
+				t.Errorf("body = %q, want it to name %q", body, tt.wantCheck)
+			}
+			if !resp.Close {
+				t.Error("connection not closed after error response")
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"math"
+	"net/http"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+// checkAllocs fails if f allocates much more than the input could explain.
+func checkAllocs(t *testing.T, input []byte, f func()) {
+	t.Helper()
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	f()
+	runtime.ReadMemStats(&after)
+	if n, limit := after.TotalAlloc-before.TotalAlloc, uint64(64*len(input)+64<<10); n > limit {
+		t.Errorf("allocated %d bytes for %d bytes of input, want at most %d", n, len(input), limit)
+	}
+}
+
+// parsedRequest is what a parser made of a request.
+type parsedRequest struct {
+	Method string
+	URL    string
+	Host   string
+	Header http.Header
+	Body   string
+}
+
+// readFirstRequest parses the request in data the way handleConnection
+// does. ok is false if it was rejected or its body is incomplete.
+func readFirstRequest(data []byte) (pr parsedRequest, ok bool) {
+	limitReader := io.LimitReader(bytes.NewReader(data), DefaultMaxHeaderBytes).(*io.LimitedReader)
+	reader := bufio.NewReader(limitReader)
+	req, err := readRequest(reader, limitReader, DefaultMaxHeaderCount)
+	if err != nil {
+		return pr, false
+	}
+	limitReader.N = math.MaxInt64
+	body, err := io.ReadAll(&bodyReader{reader: io.LimitReader(reader, req.ContentLength), n: req.ContentLength})
+	if err != nil {
+		return pr, false
+	}
+	return parsedRequest{req.Method, req.URL.String(), req.Host, req.Header, string(body)}, true
+}
+
+// FuzzReadRequest feeds arbitrary bytes to the request parser. Whatever it
+// accepts, net/http must read the same way.
+func FuzzReadRequest(f *testing.F) {
+	for _, seed := range []string{
+		"GET / HTTP/1.0\r\n\r\n",
+		"GET http://a/b?c=d HTTP/1.0\r\nHost: b\r\nAccept: */*\r\nAccept: text/html\r\n\r\n",
+		"GET / HTTP/1.0\r\nConnection: keep-alive\r\nPragma: no-cache\r\n\r\n",
+		"GET / HTTP/1.1\r\nHost: a\r\n\r\n",
+		"CONNECT a:443 HTTP/1.0\r\n\r\n",
+		"POST / HTTP/1.0\r\nContent-Length: 5\r\n\r\nhello",
+		"POST / HTTP/1.0\r\nContent-Length: 5\r\nContent-Length: 5\r\n\r\nhello",
+		"POST / HTTP/1.0\r\nTransfer-Encoding: chunked\r\nContent-Length: 5\r\n\r\nhello",
+		"POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\n\r\n",
+		"GET / HTTP/1.0\r\nX-A: b\r\n c\r\n\r\n",
+		"GET / HTTP/1.0\nX-A: b\n\n",
+	} {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var got parsedRequest
+		var ok bool
+		checkAllocs(t, data, func() {
+			got, ok = readFirstRequest(data)
+		})
+		if !ok {
+			return
+		}
+
+		req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(data)))
+		if err != nil {
+			t.Fatalf("accepted %q, net/http rejects it: %v", data, err)
+		}
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			t.Fatalf("accepted %q, net/http fails reading the body: %v", data, err)
+		}
+		want := parsedRequest{req.Method, req.URL.String(), req.Host, req.Header, string(body)}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("request %q\n got %+v\nwant %+v", data, got, want)
+		}
+	})
+}
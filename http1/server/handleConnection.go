@@ -2,7 +2,6 @@ package server
 
 import (
 	"bufio"
-	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -10,90 +9,58 @@ import (
 	"math"
 	"net"
 	"net/http"
-	"net/textproto"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/kianooshaz/http-from-scratch/internal/httperr"
+	"github.com/kianooshaz/http-from-scratch/internal/parser"
 )
 
 func (s *Server) handleConnection(conn net.Conn) error {
 	defer conn.Close()
 
-	// Limit headers to 1MB
-	limitReader := io.LimitReader(conn, 1*1024*1024).(*io.LimitedReader)
-	reader := bufio.NewReader(limitReader)
-	headerReader := textproto.NewReader(reader)
-
-	// Read the request line: GET /path/to/index.html HTTP/1.0
-	reqLine, err := headerReader.ReadLine()
-	if err != nil {
-		return fmt.Errorf("read request line error: %w", err)
-	}
-
-	req := new(http.Request)
-	var found bool
-
-	// Parse Method: GET/POST/PUT/DELETE/etc
-	req.Method, reqLine, found = strings.Cut(reqLine, " ")
-	if !found {
-		return errors.New("invalid method")
+	t0 := time.Now()
+	var hdrDeadline, wholeReqDeadline time.Time
+	if d := s.readHeaderTimeout(); d > 0 {
+		hdrDeadline = t0.Add(d)
 	}
-	if !methodValid(req.Method) {
-		return errors.New("invalid method")
+	if d := s.ReadTimeout; d > 0 {
+		wholeReqDeadline = t0.Add(d)
 	}
+	conn.SetReadDeadline(hdrDeadline)
 
-	// Parse Request URI
-	req.RequestURI, reqLine, found = strings.Cut(reqLine, " ")
-	if !found {
-		return errors.New("invalid path")
-	}
-	if req.URL, err = url.ParseRequestURI(req.RequestURI); err != nil {
-		return fmt.Errorf("invalid path: %w", err)
-	}
+	limitReader := io.LimitReader(conn, int64(s.maxHeaderBytes())).(*io.LimitedReader)
+	reader := bufio.NewReader(limitReader)
 
-	// Parse protocol version "HTTP/1.0"
-	req.Proto = reqLine
-	req.ProtoMajor, req.ProtoMinor, found = parseProtocol(req.Proto)
-	if !found {
-		return errors.New("invalid proto")
+	req, err := readRequest(reader, limitReader, s.maxHeaderCount())
+	if err != nil {
+		return rejectRequest(conn, err)
 	}
-
-	// Parse headers
-	req.Header = make(http.Header)
-	for {
-		line, err := headerReader.ReadLineBytes()
-		if err != nil && err != io.EOF {
-			return err
-		} else if err != nil {
-			break
-		}
-		if len(line) == 0 {
-			break
-		}
-
-		k, v, ok := bytes.Cut(line, []byte{':'})
-		if !ok {
-			return errors.New("invalid header")
-		}
-		req.Header.Add(strings.ToLower(string(k)), strings.TrimLeft(string(v), " "))
+	if s.MaxBodyBytes > 0 && req.ContentLength > s.MaxBodyBytes {
+		return rejectRequest(conn, &RequestError{StatusCode: http.StatusRequestEntityTooLarge, Check: "body too large", Err: &http.MaxBytesError{Limit: s.MaxBodyBytes}})
 	}
 
 	// Unbound the limit after we've read the headers since the body can be any size
 	limitReader.N = math.MaxInt64
 
+	// The body gets what's left of ReadTimeout, the response gets WriteTimeout.
+	conn.SetReadDeadline(wholeReqDeadline)
+	if d := s.WriteTimeout; d > 0 {
+		conn.SetWriteDeadline(time.Now().Add(d))
+	}
+
 	ctx := context.Background()
+	// Handlers find our *Server there, not an *http.Server.
+	ctx = context.WithValue(ctx, http.ServerContextKey, s)
 	ctx = context.WithValue(ctx, http.LocalAddrContextKey, conn.LocalAddr())
 	ctx, cancelCtx := context.WithCancel(ctx)
 	defer cancelCtx()
-	contentLength, err := parseContentLength(req.Header.Get("Content-Length"))
-	if err != nil {
-		return err
-	}
-	req.ContentLength = contentLength
 	if req.ContentLength == 0 {
-		req.Body = noBody{}
+		req.Body = http.NoBody
 	} else {
-		req.Body = &bodyReader{reader: io.LimitReader(reader, req.ContentLength)}
+		req.Body = &bodyReader{reader: io.LimitReader(reader, req.ContentLength), n: req.ContentLength}
 	}
 
 	req.RemoteAddr = conn.RemoteAddr().String()
@@ -104,24 +71,172 @@ func (s *Server) handleConnection(conn net.Conn) error {
 		// Web servers will make requests with HTTP/1.1 but
 		// we're saying that we only support HTTP/1.0.
 		proto:   "HTTP/1.0",
-		conn:    conn,
+		bufw:    bufio.NewWriter(conn),
 		headers: make(http.Header),
+		head:    req.Method == http.MethodHead,
 	}
 
 	// Finally, call our http.Handler!
 	s.Handler.ServeHTTP(w, req.WithContext(ctx))
-	if !w.sentHeaders {
-		w.sendHeaders(http.StatusOK)
-	}
+	w.finish()
 	return nil
 }
 
-func parseContentLength(headerval string) (int64, error) {
-	if headerval == "" {
-		return 0, nil
+// rejectRequest answers a request that failed one of our checks. Other
+// errors, like the client going away, are returned untouched.
+func rejectRequest(conn net.Conn, err error) error {
+	var reqErr *RequestError
+	if errors.As(err, &reqErr) {
+		writeErrorResponse(conn, reqErr)
+		// The client may still be sending.
+		httperr.CloseWriteAndWait(conn)
+	}
+	return err
+}
+
+// readRequest reads the request line and at most maxHeaderCount header
+// fields from reader. Requests we can't accept are reported as a
+// *RequestError.
+func readRequest(reader *bufio.Reader, limitReader *io.LimitedReader, maxHeaderCount int) (*http.Request, error) {
+	// Read the request line: GET /path/to/index.html HTTP/1.0
+	line, err := parser.ReadLine(reader)
+	if err != nil {
+		return nil, headerReadError(limitReader, syntaxError(err))
+	}
+	reqLine, err := parser.ParseRequestLine(line)
+	if err != nil {
+		return nil, syntaxError(err)
+	}
+
+	req := &http.Request{
+		Method:     reqLine.Method,
+		RequestURI: reqLine.Target,
+		Proto:      reqLine.Proto,
+		ProtoMajor: reqLine.ProtoMajor,
+		ProtoMinor: reqLine.ProtoMinor,
+	}
+	if !methodValid(req.Method) {
+		return nil, &RequestError{StatusCode: http.StatusNotImplemented, Check: "invalid method", Err: fmt.Errorf("unsupported method %q", req.Method)}
+	}
+	// CONNECT takes an authority, host:port, instead of a path. Parse it the
+	// way net/http does.
+	rawURL := req.RequestURI
+	justAuthority := req.Method == http.MethodConnect && !strings.HasPrefix(rawURL, "/")
+	if justAuthority {
+		rawURL = "http://" + rawURL
+	}
+	if req.URL, err = url.ParseRequestURI(rawURL); err != nil {
+		return nil, badRequest("invalid path", err)
+	}
+	if justAuthority {
+		req.URL.Scheme = ""
+	}
+	// The version is well formed, but one we may not speak.
+	if _, _, ok := parseProtocol(req.Proto); !ok {
+		return nil, &RequestError{StatusCode: http.StatusHTTPVersionNotSupported, Check: "invalid protocol", Err: fmt.Errorf("unsupported protocol %q", req.Proto)}
+	}
+
+	// Parse headers
+	req.Header = make(http.Header)
+	for count := 0; ; count++ {
+		line, err := parser.ReadLine(reader)
+		if err == io.EOF {
+			return nil, headerReadError(limitReader, io.ErrUnexpectedEOF)
+		}
+		if err != nil {
+			return nil, headerReadError(limitReader, syntaxError(err))
+		}
+		if len(line) == 0 {
+			break
+		}
+		if count == maxHeaderCount {
+			return nil, &RequestError{StatusCode: http.StatusRequestHeaderFieldsTooLarge, Check: "too many headers", Err: fmt.Errorf("more than %d header fields", maxHeaderCount)}
+		}
+
+		k, v, err := parser.ParseHeaderField(line)
+		if err != nil {
+			return nil, syntaxError(err)
+		}
+		req.Header.Add(k, v)
+	}
+
+	// HTTP/1.0 clients may leave out Host, HTTP/1.1 ones must send exactly
+	// one. The host moves from the header to req.Host, preferring the one
+	// of an absolute request URI.
+	hosts, haveHost := req.Header["Host"]
+	if req.ProtoAtLeast(1, 1) && !haveHost {
+		return nil, badRequest("missing Host header", nil)
+	}
+	if len(hosts) > 1 {
+		return nil, badRequest("too many Host headers", nil)
+	}
+	req.Host = req.URL.Host
+	if req.Host == "" && len(hosts) == 1 {
+		req.Host = hosts[0]
+	}
+	req.Header.Del("Host")
+
+	// A lone "Pragma: no-cache" from an HTTP/1.0 cache means Cache-Control:
+	// no-cache, see RFC 9111 section 5.4.
+	if len(req.Header["Pragma"]) > 0 && req.Header.Get("Pragma") == "no-cache" {
+		if _, ok := req.Header["Cache-Control"]; !ok {
+			req.Header.Set("Cache-Control", "no-cache")
+		}
+	}
+
+	// HTTP/1.0 has no transfer codings, net/http drops the header too. We
+	// don't decode any for HTTP/1.1 clients either, and guessing where such
+	// a body ends is how requests get smuggled.
+	if _, ok := req.Header["Transfer-Encoding"]; ok {
+		if req.ProtoAtLeast(1, 1) {
+			return nil, &RequestError{StatusCode: http.StatusNotImplemented, Check: "unsupported Transfer-Encoding", Err: fmt.Errorf("codings %q", req.Header["Transfer-Encoding"])}
+		}
+		req.Header.Del("Transfer-Encoding")
+	}
+
+	// More than one Content-Length is only fine if they all agree, they are
+	// folded into one then.
+	if lengths, ok := req.Header["Content-Length"]; ok {
+		n, repeated, err := parser.ParseContentLength(lengths)
+		if err != nil {
+			return nil, badRequest("invalid Content-Length", err)
+		}
+		req.ContentLength = n
+		if repeated {
+			req.Header.Set("Content-Length", strconv.FormatInt(n, 10))
+		}
+	}
+
+	return req, nil
+}
+
+// headerReadError turns a read error caused by running into the header size
+// limit into a *RequestError.
+func headerReadError(limitReader *io.LimitedReader, err error) error {
+	if limitReader.N <= 0 {
+		return &RequestError{StatusCode: http.StatusRequestHeaderFieldsTooLarge, Check: "headers too large"}
 	}
+	return err
+}
 
-	return strconv.ParseInt(headerval, 10, 64)
+// syntaxError turns a *parser.SyntaxError into a 400 named after the part
+// of the request head it is about. Other errors are returned as is.
+func syntaxError(err error) error {
+	var synErr *parser.SyntaxError
+	if !errors.As(err, &synErr) {
+		return err
+	}
+	switch synErr.Part {
+	case parser.PartMethod:
+		return badRequest("invalid method", err)
+	case parser.PartRequestTarget:
+		return badRequest("invalid path", err)
+	case parser.PartHTTPVersion:
+		return badRequest("invalid protocol", err)
+	case parser.PartFieldName, parser.PartFieldValue:
+		return badRequest("invalid header", err)
+	}
+	return badRequest("invalid line", err)
 }
 
 func parseProtocol(proto string) (int, int, bool) {
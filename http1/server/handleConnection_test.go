@@ -0,0 +1,220 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kianooshaz/http-from-scratch/internal/servertest"
+)
+
+func TestRequestMatchesNetHTTP(t *testing.T) {
+	got := make(chan servertest.Request, 1)
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got <- servertest.Snapshot(r)
+	})
+	addr := newTestServer(t, h)
+	ref := httptest.NewServer(h)
+	t.Cleanup(ref.Close)
+	refAddr := ref.Listener.Addr().String()
+
+	// We always close after the response, so only requests net/http would
+	// close after too.
+	tests := []struct {
+		name string
+		raw  string
+	}{
+		{"get", "GET /a?b=c HTTP/1.0\r\nUser-Agent: test\r\n\r\n"},
+		{"host", "GET / HTTP/1.0\r\nHost: example.com:8080\r\n\r\n"},
+		{"absolute uri", "GET http://example.com/a HTTP/1.0\r\nHost: other.example\r\n\r\n"},
+		{"http/1.1 close", "GET / HTTP/1.1\r\nHost: a\r\nConnection: close\r\n\r\n"},
+		{"header whitespace", "GET / HTTP/1.0\r\nx-lower: \t padded \t\r\nX-Dup: 1\r\nX-Dup: 2\r\n\r\n"},
+		{"pragma", "GET / HTTP/1.0\r\nPragma: no-cache\r\n\r\n"},
+		{"content-length body", "POST / HTTP/1.0\r\nContent-Type: text/plain\r\nContent-Length: 5\r\n\r\nhello"},
+		{"transfer-encoding ignored", "POST / HTTP/1.0\r\nTransfer-Encoding: chunked\r\nContent-Length: 5\r\n\r\nhello"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := servertest.Capture(t, refAddr, tt.raw, got)
+			have := servertest.Capture(t, addr, tt.raw, got)
+			if !reflect.DeepEqual(have, want) {
+				t.Errorf("request differs from net/http\n got: %+v\nwant: %+v", have, want)
+			}
+		})
+	}
+}
+
+func TestServerLimits(t *testing.T) {
+	addr := startTestServer(t, &Server{
+		MaxHeaderBytes: 256,
+		MaxHeaderCount: 3,
+		MaxBodyBytes:   5,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/ok" {
+				t.Errorf("handler called for %s", r.URL.Path)
+			}
+		}),
+	})
+
+	tests := []struct {
+		name       string
+		request    string
+		wantStatus int
+		wantCheck  string
+	}{
+		{"headers at limit", "GET /ok HTTP/1.0\r\nX-A: 1\r\nX-B: 2\r\nX-C: 3\r\n\r\n", http.StatusOK, ""},
+		{"too many headers", "GET / HTTP/1.0\r\nX-A: 1\r\nX-B: 2\r\nX-C: 3\r\nX-D: 4\r\n\r\n", http.StatusRequestHeaderFieldsTooLarge, "too many headers"},
+		{"headers too large", "GET / HTTP/1.0\r\nX-Big: " + strings.Repeat("a", 256) + "\r\n\r\n", http.StatusRequestHeaderFieldsTooLarge, "headers too large"},
+		{"body at limit", "POST /ok HTTP/1.0\r\nContent-Length: 5\r\n\r\nhello", http.StatusOK, ""},
+		{"body too large", "POST / HTTP/1.0\r\nContent-Length: 6\r\n\r\nhello!", http.StatusRequestEntityTooLarge, "body too large"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			conn, err := net.Dial("tcp", addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+			go io.WriteString(conn, tt.request)
+
+			resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if !strings.HasSuffix(string(body), tt.wantCheck) {
+				t.Errorf("body = %q, want it to name %q", body, tt.wantCheck)
+			}
+		})
+	}
+}
+
+// TestTransferEncodingRejected checks that an HTTP/1.1 chunked body isn't
+// read as if it had no body, leaving the chunks to be parsed as the next
+// request by whoever reuses the connection.
+func TestTransferEncodingRejected(t *testing.T) {
+	addr := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("handler called for %s %s", r.Method, r.RequestURI)
+	}))
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	go io.WriteString(conn, "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\n\r\n")
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusNotImplemented || !strings.HasSuffix(string(body), "unsupported Transfer-Encoding") {
+		t.Errorf("got %d %q, want %d naming %q", resp.StatusCode, body, http.StatusNotImplemented, "unsupported Transfer-Encoding")
+	}
+}
+
+func TestContentLength(t *testing.T) {
+	got := make(chan string, 1)
+	addr := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got <- strings.Join(r.Header["Content-Length"], "|") + " " + string(body)
+	}))
+
+	tests := []struct {
+		name       string
+		header     string
+		wantStatus int
+		want       string // what the handler saw
+	}{
+		{"plain", "Content-Length: 5\r\n", http.StatusOK, "5 hello"},
+		{"repeated", "Content-Length: 5\r\nContent-Length: 5\r\n", http.StatusOK, "5 hello"},
+		{"list", "Content-Length: 5, 5\r\n", http.StatusOK, "5 hello"},
+		{"conflicting list", "Content-Length: 5, 6\r\n", http.StatusBadRequest, ""},
+		{"conflicting", "Content-Length: 5\r\nContent-Length: 6\r\n", http.StatusBadRequest, ""},
+		{"sign", "Content-Length: +5\r\n", http.StatusBadRequest, ""},
+		{"empty", "Content-Length:\r\n", http.StatusBadRequest, ""},
+		{"overflow", "Content-Length: 18446744073709551616\r\n", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+			go io.WriteString(conn, "POST / HTTP/1.0\r\n"+tt.header+"\r\nhello")
+
+			resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				if !strings.HasSuffix(string(body), "invalid Content-Length") {
+					t.Errorf("body = %q, want it to name %q", body, "invalid Content-Length")
+				}
+				return
+			}
+			if seen := <-got; seen != tt.want {
+				t.Errorf("handler saw %q, want %q", seen, tt.want)
+			}
+		})
+	}
+}
+
+// TestReadHeaderTimeout plays a slowloris client, sending a header line
+// every so often but never finishing the request.
+func TestReadHeaderTimeout(t *testing.T) {
+	addr := startTestServer(t, &Server{
+		ReadHeaderTimeout: 100 * time.Millisecond,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			t.Error("handler called for an unfinished request")
+		}),
+	})
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	start := time.Now()
+	go func() {
+		if _, err := io.WriteString(conn, "GET / HTTP/1.0\r\n"); err != nil {
+			return
+		}
+		for {
+			time.Sleep(20 * time.Millisecond)
+			if _, err := io.WriteString(conn, "X-Slow: 1\r\n"); err != nil {
+				return
+			}
+		}
+	}()
+
+	// Closed, or reset if our last header line was still unread.
+	if _, err := io.ReadAll(conn); errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatal("connection not closed by the server")
+	}
+	if d := time.Since(start); d < 100*time.Millisecond || d > time.Second {
+		t.Errorf("connection closed after %v, want about 100ms", d)
+	}
+}
@@ -1,19 +1,24 @@
 package server
 
 import (
+	"bufio"
 	"fmt"
 	"io"
 	"log/slog"
-	"net"
 	"net/http"
 	"strconv"
 )
 
 type responseBodyWriter struct {
 	proto       string
-	conn        net.Conn
+	bufw        *bufio.Writer // flushed by finish and Flush
 	sentHeaders bool
 	headers     http.Header
+
+	// head is set for HEAD requests, whose responses never carry a body.
+	// written counts what the handler tried to write anyway.
+	head    bool
+	written int64
 }
 
 func (r *responseBodyWriter) Header() http.Header {
@@ -21,10 +26,36 @@ func (r *responseBodyWriter) Header() http.Header {
 }
 
 func (r *responseBodyWriter) Write(b []byte) (int, error) {
+	// Keep the headers of a HEAD response pending so finish can still tell
+	// the client how long the body would have been.
+	if r.head {
+		r.written += int64(len(b))
+		return len(b), nil
+	}
+	if !r.sentHeaders {
+		r.sendHeaders(http.StatusOK)
+	}
+	return r.bufw.Write(b)
+}
+
+// Flush sends the headers if needed and whatever has been written so far.
+func (r *responseBodyWriter) Flush() {
 	if !r.sentHeaders {
 		r.sendHeaders(http.StatusOK)
 	}
-	return r.conn.Write(b)
+	r.bufw.Flush()
+}
+
+// finish sends the headers if the handler never did and flushes the rest
+// of the response to the connection.
+func (r *responseBodyWriter) finish() {
+	if !r.sentHeaders {
+		if _, clSet := r.headers["Content-Length"]; r.head && r.written > 0 && !clSet {
+			r.headers.Set("Content-Length", strconv.FormatInt(r.written, 10))
+		}
+		r.sendHeaders(http.StatusOK)
+	}
+	r.bufw.Flush()
 }
 
 func (r *responseBodyWriter) WriteHeader(statusCode int) {
@@ -37,19 +68,19 @@ func (r *responseBodyWriter) WriteHeader(statusCode int) {
 
 func (r *responseBodyWriter) sendHeaders(statusCode int) {
 	r.sentHeaders = true
-	io.WriteString(r.conn, r.proto)
-	r.conn.Write([]byte{' '})
-	io.WriteString(r.conn, strconv.FormatInt(int64(statusCode), 10))
-	r.conn.Write([]byte{' '})
-	io.WriteString(r.conn, http.StatusText(statusCode))
-	r.conn.Write([]byte{'\r', '\n'})
+	io.WriteString(r.bufw, r.proto)
+	r.bufw.Write([]byte{' '})
+	io.WriteString(r.bufw, strconv.FormatInt(int64(statusCode), 10))
+	r.bufw.Write([]byte{' '})
+	io.WriteString(r.bufw, http.StatusText(statusCode))
+	r.bufw.Write([]byte{'\r', '\n'})
 	for k, vals := range r.headers {
 		for _, val := range vals {
-			io.WriteString(r.conn, k)
-			r.conn.Write([]byte{':', ' '})
-			io.WriteString(r.conn, val)
-			r.conn.Write([]byte{'\r', '\n'})
+			io.WriteString(r.bufw, k)
+			r.bufw.Write([]byte{':', ' '})
+			io.WriteString(r.bufw, val)
+			r.bufw.Write([]byte{'\r', '\n'})
 		}
 	}
-	r.conn.Write([]byte{'\r', '\n'})
+	r.bufw.Write([]byte{'\r', '\n'})
 }
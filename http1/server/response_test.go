@@ -0,0 +1,87 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// newTestServer starts a Server with h on a loopback port and returns the
+// address to dial.
+func newTestServer(t *testing.T, h http.Handler) string {
+	t.Helper()
+
+	return startTestServer(t, &Server{Handler: h})
+}
+
+// startTestServer serves s on a loopback port and returns the address to
+// dial. Configure s before calling it.
+func startTestServer(t *testing.T, s *Server) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go s.Serve(l)
+	return l.Addr().String()
+}
+
+// roundTrip sends a raw request and returns the parsed response and any
+// bytes the server sent after it.
+func roundTrip(t *testing.T, addr, method, path string) (*http.Response, []byte, []byte) {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	io.WriteString(conn, method+" "+path+" HTTP/1.0\r\n\r\n")
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, &http.Request{Method: method})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rest, _ := io.ReadAll(br)
+	return resp, body, rest
+}
+
+func TestHeadResponses(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/", http.FileServer(http.Dir("testdata")))
+	mux.HandleFunc("/written", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "<html>hello</html>")
+	})
+	addr := newTestServer(t, mux)
+
+	for _, path := range []string{"/hello.txt", "/written"} {
+		t.Run(path, func(t *testing.T) {
+			get, body, _ := roundTrip(t, addr, http.MethodGet, path)
+			head, _, rest := roundTrip(t, addr, http.MethodHead, path)
+
+			if len(rest) != 0 {
+				t.Errorf("HEAD response carried %d body bytes", len(rest))
+			}
+			if head.ContentLength != int64(len(body)) {
+				t.Errorf("HEAD Content-Length = %d, want %d", head.ContentLength, len(body))
+			}
+			for _, k := range []string{"Content-Type", "Last-Modified", "Accept-Ranges"} {
+				if got, want := head.Header.Get(k), get.Header.Get(k); got != want {
+					t.Errorf("HEAD %s = %q, GET has %q", k, got, want)
+				}
+			}
+		})
+	}
+}
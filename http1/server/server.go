@@ -5,23 +5,62 @@ import (
 	"log/slog"
 	"net"
 	"net/http"
+	"time"
 )
 
+// DefaultMaxHeaderBytes is used when Server.MaxHeaderBytes is zero.
+const DefaultMaxHeaderBytes = 1 << 20
+
+// DefaultMaxHeaderCount is used when Server.MaxHeaderCount is zero.
+const DefaultMaxHeaderCount = 100
+
+// Server serves HTTP/1.0. There is no IdleTimeout: the connection is
+// closed after every response, so it is never idle between requests.
 type Server struct {
 	Addr    string
 	Handler http.Handler
+
+	// ReadTimeout is the maximum duration for reading the entire request,
+	// including the body. Zero or negative means no timeout.
+	ReadTimeout time.Duration
+
+	// ReadHeaderTimeout is the amount of time allowed to read the request
+	// line and headers. If zero, ReadTimeout is used.
+	ReadHeaderTimeout time.Duration
+
+	// WriteTimeout is the maximum duration before timing out writes of the
+	// response, counted from the end of the request headers.
+	WriteTimeout time.Duration
+
+	// MaxHeaderBytes caps the size of the request line and headers, a
+	// request over it gets a 431. If zero, DefaultMaxHeaderBytes is used.
+	MaxHeaderBytes int
+
+	// MaxHeaderCount caps the number of header fields of a request, a
+	// request over it gets a 431. If zero, DefaultMaxHeaderCount is used.
+	MaxHeaderCount int
+
+	// MaxBodyBytes caps the size of a request body, a request announcing a
+	// longer one gets a 413 without reaching the Handler. Zero or negative
+	// means no limit.
+	MaxBodyBytes int64
 }
 
 func (s *Server) ListenAndServe() error {
-	if s.Handler == nil {
-		s.Handler = http.DefaultServeMux
-	}
-
 	l, err := net.Listen("tcp", s.Addr)
 	if err != nil {
 		return err
 	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections on l and handles each in its own goroutine.
+// It always returns a non-nil error and closes l.
+func (s *Server) Serve(l net.Listener) error {
 	defer l.Close()
+	if s.Handler == nil {
+		s.Handler = http.DefaultServeMux
+	}
 
 	for {
 		conn, err := l.Accept()
@@ -36,3 +75,24 @@ func (s *Server) ListenAndServe() error {
 		}()
 	}
 }
+
+func (s *Server) maxHeaderBytes() int {
+	if s.MaxHeaderBytes > 0 {
+		return s.MaxHeaderBytes
+	}
+	return DefaultMaxHeaderBytes
+}
+
+func (s *Server) maxHeaderCount() int {
+	if s.MaxHeaderCount > 0 {
+		return s.MaxHeaderCount
+	}
+	return DefaultMaxHeaderCount
+}
+
+func (s *Server) readHeaderTimeout() time.Duration {
+	if s.ReadHeaderTimeout != 0 {
+		return s.ReadHeaderTimeout
+	}
+	return s.ReadTimeout
+}
@@ -0,0 +1,69 @@
+// Package httperr is how the servers reject a request before it reaches
+// the Handler: the error they return, the response they answer with and
+// how they close the connection after it.
+package httperr
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// rstAvoidanceDelay is how long CloseWriteAndWait gives the client.
+const rstAvoidanceDelay = 500 * time.Millisecond
+
+// RequestError is returned when a request is rejected before it reaches
+// the Handler. By the time it is returned the server has already answered
+// the client with StatusCode and the connection is being closed.
+type RequestError struct {
+	// StatusCode is the status sent to the client.
+	StatusCode int
+	// Check names the check that failed, e.g. "invalid method".
+	Check string
+	// Err is the underlying error, if any.
+	Err error
+}
+
+func (e *RequestError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %s", e.Check, e.Err)
+	}
+	return e.Check
+}
+
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
+
+// BadRequest is a 400 for the failed check.
+func BadRequest(check string, err error) *RequestError {
+	return &RequestError{StatusCode: http.StatusBadRequest, Check: check, Err: err}
+}
+
+// CloseWriteAndWait prepares conn for closing while the client may still
+// be sending. Closing with unread data would reset the connection and could
+// destroy the response before it is read, so stop writing and give the
+// client a moment first.
+func CloseWriteAndWait(conn net.Conn) {
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+		time.Sleep(rstAvoidanceDelay)
+	}
+}
+
+// WriteResponse answers a rejected request with a proto status line, e.g.
+// "HTTP/1.1". The body names the failed check so a client has a chance to
+// see what it did wrong.
+func WriteResponse(w io.Writer, proto string, reqErr *RequestError) error {
+	body := strconv.Itoa(reqErr.StatusCode) + " " + http.StatusText(reqErr.StatusCode) + ": " + reqErr.Check
+	_, err := io.WriteString(w, proto+" "+strconv.Itoa(reqErr.StatusCode)+" "+http.StatusText(reqErr.StatusCode)+"\r\n"+
+		"Content-Type: text/plain; charset=utf-8\r\n"+
+		"Content-Length: "+strconv.Itoa(len(body))+"\r\n"+
+		"Connection: close\r\n"+
+		"\r\n"+
+		body)
+	return err
+}
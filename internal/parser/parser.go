@@ -0,0 +1,375 @@
+// Package parser parses the request line, header fields and chunk lines of
+// an HTTP/1.x message the strict way, following the grammar of RFC 9110 and RFC 9112.
+// Anything the grammar doesn't allow is rejected with a *SyntaxError
+// pointing at the offending byte, rather than being guessed at: lenient
+// parsing is what request smuggling feeds on.
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+// Parts of a message a SyntaxError can be about.
+const (
+	PartLine          = "line"
+	PartMethod        = "method"
+	PartRequestTarget = "request-target"
+	PartHTTPVersion   = "HTTP-version"
+	PartFieldName     = "field-name"
+	PartFieldValue    = "field-value"
+	PartChunkSize     = "chunk-size"
+	PartChunkExt      = "chunk-ext"
+)
+
+// SyntaxError reports malformed input.
+type SyntaxError struct {
+	// Part is the part of the grammar that didn't match, one of the Part
+	// constants.
+	Part string
+	// Offset is the byte offset of the problem within the line.
+	Offset int
+	Msg    string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("parser: invalid %s at offset %d: %s", e.Part, e.Offset, e.Msg)
+}
+
+// RequestLine is a parsed request-line, see RFC 9112 section 3.
+type RequestLine struct {
+	Method string
+	Target string
+	Proto  string
+	// ProtoMajor and ProtoMinor are the digits of Proto.
+	ProtoMajor int
+	ProtoMinor int
+}
+
+// ParseRequestLine parses "method SP request-target SP HTTP-version". Each
+// part is separated by exactly one space. The request-target is only
+// checked for characters that may not appear in it, its form is the
+// caller's business.
+func ParseRequestLine(line []byte) (RequestLine, error) {
+	var rl RequestLine
+
+	i := 0
+	for i < len(line) && isTokenChar(line[i]) {
+		i++
+	}
+	if i == 0 {
+		return rl, &SyntaxError{Part: PartMethod, Offset: 0, Msg: "empty method"}
+	}
+	if i == len(line) || line[i] != ' ' {
+		return rl, &SyntaxError{Part: PartMethod, Offset: i, Msg: "expected space after method"}
+	}
+	rl.Method = string(line[:i])
+	i++
+
+	start := i
+	for i < len(line) && isVChar(line[i]) {
+		i++
+	}
+	if i == start {
+		return rl, &SyntaxError{Part: PartRequestTarget, Offset: i, Msg: "empty request-target"}
+	}
+	if i == len(line) || line[i] != ' ' {
+		return rl, &SyntaxError{Part: PartRequestTarget, Offset: i, Msg: "expected space after request-target"}
+	}
+	rl.Target = string(line[start:i])
+	i++
+
+	// HTTP-version = "HTTP/" DIGIT "." DIGIT
+	version := line[i:]
+	if len(version) != len("HTTP/x.y") || !bytes.HasPrefix(version, []byte("HTTP/")) ||
+		!isDigit(version[5]) || version[6] != '.' || !isDigit(version[7]) {
+		return rl, &SyntaxError{Part: PartHTTPVersion, Offset: i, Msg: "expected HTTP/DIGIT.DIGIT"}
+	}
+	rl.Proto = string(version)
+	rl.ProtoMajor = int(version[5] - '0')
+	rl.ProtoMinor = int(version[7] - '0')
+	return rl, nil
+}
+
+// ParseHeaderField parses "field-name: field-value", see RFC 9112 section 5.
+// No whitespace is allowed between the name and the colon, the optional
+// whitespace around the value is trimmed. A line starting with whitespace
+// is an obs-fold continuation, which is rejected.
+func ParseHeaderField(line []byte) (name, value string, err error) {
+	if len(line) > 0 && (line[0] == ' ' || line[0] == '\t') {
+		return "", "", &SyntaxError{Part: PartFieldName, Offset: 0, Msg: "obsolete line folding"}
+	}
+
+	i := 0
+	for i < len(line) && isTokenChar(line[i]) {
+		i++
+	}
+	switch {
+	case i == len(line):
+		return "", "", &SyntaxError{Part: PartFieldName, Offset: i, Msg: "missing colon"}
+	case line[i] != ':' && (line[i] == ' ' || line[i] == '\t'):
+		return "", "", &SyntaxError{Part: PartFieldName, Offset: i, Msg: "whitespace before colon"}
+	case line[i] != ':':
+		return "", "", &SyntaxError{Part: PartFieldName, Offset: i, Msg: "invalid character in field name"}
+	case i == 0:
+		return "", "", &SyntaxError{Part: PartFieldName, Offset: 0, Msg: "empty field name"}
+	}
+	name = string(line[:i])
+
+	// OWS field-value OWS
+	start, end := i+1, len(line)
+	for start < end && isOWS(line[start]) {
+		start++
+	}
+	for end > start && isOWS(line[end-1]) {
+		end--
+	}
+	for j := start; j < end; j++ {
+		if !isFieldValueChar(line[j]) {
+			return "", "", &SyntaxError{Part: PartFieldValue, Offset: j, Msg: "invalid character in field value"}
+		}
+	}
+	return name, string(line[start:end]), nil
+}
+
+// ValidToken reports whether s is a non-empty token, see RFC 9110 section
+// 5.6.2.
+func ValidToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isTokenChar(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseContentLength parses the Content-Length field lines of a message.
+// Each value is 1*DIGIT: no sign, no whitespace, nothing strconv would let
+// through beyond that. A list of equal values, on one line or several, is
+// allowed by RFC 9112 section 6.3 and folded into one; repeated reports
+// whether there was more than one.
+func ParseContentLength(values []string) (n int64, repeated bool, err error) {
+	n = -1
+	count := 0
+	for _, v := range values {
+		for _, s := range strings.Split(v, ",") {
+			m, err := parseDecimal(strings.Trim(s, " \t"))
+			if err != nil {
+				return 0, false, err
+			}
+			if n != -1 && m != n {
+				return 0, false, fmt.Errorf("conflicting Content-Length values %d and %d", n, m)
+			}
+			n = m
+			count++
+		}
+	}
+	if count == 0 {
+		return 0, false, errors.New("empty Content-Length")
+	}
+	return n, count > 1, nil
+}
+
+func parseDecimal(s string) (int64, error) {
+	if s == "" {
+		return 0, errors.New("empty Content-Length")
+	}
+	var n int64
+	for i := 0; i < len(s); i++ {
+		if !isDigit(s[i]) {
+			return 0, fmt.Errorf("invalid Content-Length %q", s)
+		}
+		if n > (math.MaxInt64-int64(s[i]-'0'))/10 {
+			return 0, fmt.Errorf("Content-Length %q overflows", s)
+		}
+		n = n*10 + int64(s[i]-'0')
+	}
+	return n, nil
+}
+
+// ChunkExtension is one ";name=value" of a chunk line.
+type ChunkExtension struct {
+	Name string
+	// Value is unquoted, empty if the extension has none.
+	Value string
+}
+
+// ParseChunkLine parses "chunk-size [ chunk-ext ]", see RFC 9112 section
+// 7.1. Like net/http we take at most 16 hex digits, and the size must fit
+// in an int64. The whitespace the grammar allows around ";" and "=" is
+// rejected, as net/http does.
+func ParseChunkLine(line []byte) (size int64, exts []ChunkExtension, err error) {
+	i := 0
+	for ; i < len(line); i++ {
+		d, ok := unhex(line[i])
+		if !ok {
+			break
+		}
+		if i == 16 {
+			return 0, nil, &SyntaxError{Part: PartChunkSize, Offset: i, Msg: "more than 16 hex digits"}
+		}
+		if size > math.MaxInt64>>4 {
+			return 0, nil, &SyntaxError{Part: PartChunkSize, Offset: i, Msg: "chunk size overflows"}
+		}
+		size = size<<4 | int64(d)
+	}
+	if i == 0 {
+		return 0, nil, &SyntaxError{Part: PartChunkSize, Offset: 0, Msg: "missing chunk size"}
+	}
+
+	// chunk-ext = *( ";" chunk-ext-name [ "=" chunk-ext-val ] )
+	for i < len(line) {
+		if line[i] != ';' {
+			return 0, nil, &SyntaxError{Part: PartChunkExt, Offset: i, Msg: "expected ;"}
+		}
+		i++
+		start := i
+		for i < len(line) && isTokenChar(line[i]) {
+			i++
+		}
+		if i == start {
+			return 0, nil, &SyntaxError{Part: PartChunkExt, Offset: i, Msg: "empty extension name"}
+		}
+		ext := ChunkExtension{Name: string(line[start:i])}
+		if i < len(line) && line[i] == '=' {
+			i++
+			if i < len(line) && line[i] == '"' {
+				ext.Value, i, err = readQuotedString(line, i)
+				if err != nil {
+					return 0, nil, err
+				}
+			} else {
+				start = i
+				for i < len(line) && isTokenChar(line[i]) {
+					i++
+				}
+				if i == start {
+					return 0, nil, &SyntaxError{Part: PartChunkExt, Offset: i, Msg: "empty extension value"}
+				}
+				ext.Value = string(line[start:i])
+			}
+		}
+		exts = append(exts, ext)
+	}
+	return size, exts, nil
+}
+
+// readQuotedString reads the quoted-string starting at line[i] and returns
+// its unquoted value and the offset after it, see RFC 9110 section 5.6.4.
+func readQuotedString(line []byte, i int) (string, int, error) {
+	var value []byte
+	for i++; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == '"':
+			return string(value), i + 1, nil
+		case c == '\\':
+			i++
+			if i == len(line) || !isFieldValueChar(line[i]) {
+				return "", 0, &SyntaxError{Part: PartChunkExt, Offset: i, Msg: "invalid quoted-pair"}
+			}
+			value = append(value, line[i])
+		case isFieldValueChar(c):
+			value = append(value, c)
+		default:
+			return "", 0, &SyntaxError{Part: PartChunkExt, Offset: i, Msg: "invalid character in quoted-string"}
+		}
+	}
+	return "", 0, &SyntaxError{Part: PartChunkExt, Offset: i, Msg: "unterminated quoted-string"}
+}
+
+// ErrLineTooLong is returned by ReadLineLimit for a line over the limit.
+var ErrLineTooLong = errors.New("parser: line too long")
+
+// ReadLine reads a line ending in CRLF and returns it without the CRLF. A
+// bare LF ends a line only in lenient parsers, here it is an error, and so
+// is a CR anywhere else in the line. The line is only valid until the next
+// read from br.
+func ReadLine(br *bufio.Reader) ([]byte, error) {
+	return ReadLineLimit(br, -1)
+}
+
+// ReadLineLimit is ReadLine for lines of at most max bytes, not counting
+// the CRLF. A negative max means no limit. A longer line is ErrLineTooLong,
+// found before more than max+2 bytes of the line have been buffered.
+func ReadLineLimit(br *bufio.Reader, max int) ([]byte, error) {
+	var line []byte
+	for {
+		l, err := br.ReadSlice('\n')
+		if max >= 0 && len(line)+len(l) > max+2 {
+			return nil, ErrLineTooLong
+		}
+		if err == bufio.ErrBufferFull {
+			// Longer than the buffer, keep a copy and go on.
+			line = append(line, l...)
+			continue
+		}
+		if err != nil {
+			if err == io.EOF && len(line)+len(l) > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		if line == nil {
+			line = l
+		} else {
+			line = append(line, l...)
+		}
+		break
+	}
+
+	line = line[:len(line)-1] // the LF
+	if len(line) == 0 || line[len(line)-1] != '\r' {
+		return nil, &SyntaxError{Part: PartLine, Offset: len(line), Msg: "line ends in a bare LF"}
+	}
+	line = line[:len(line)-1]
+	if i := bytes.IndexByte(line, '\r'); i >= 0 {
+		return nil, &SyntaxError{Part: PartLine, Offset: i, Msg: "bare CR"}
+	}
+	return line, nil
+}
+
+func unhex(c byte) (byte, bool) {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0', true
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10, true
+	case 'A' <= c && c <= 'F':
+		return c - 'A' + 10, true
+	}
+	return 0, false
+}
+
+func isDigit(c byte) bool { return '0' <= c && c <= '9' }
+
+func isOWS(c byte) bool { return c == ' ' || c == '\t' }
+
+// isVChar reports whether c is a visible ASCII character.
+func isVChar(c byte) bool { return 0x21 <= c && c <= 0x7e }
+
+// isFieldValueChar reports whether c may appear in a field value: VCHAR,
+// obs-text and whitespace. CTLs, NUL and CR in particular, may not.
+func isFieldValueChar(c byte) bool {
+	return isVChar(c) || c >= 0x80 || isOWS(c)
+}
+
+// isTokenChar reports whether c is a tchar:
+//
+//	tchar = "!" / "#" / "$" / "%" / "&" / "'" / "*" / "+" / "-" / "." /
+//	        "^" / "_" / "`" / "|" / "~" / DIGIT / ALPHA
+func isTokenChar(c byte) bool {
+	switch {
+	case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', isDigit(c):
+		return true
+	}
+	return strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0
+}
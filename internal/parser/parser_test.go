@@ -0,0 +1,265 @@
+package parser
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseRequestLine(t *testing.T) {
+	tests := []struct {
+		line   string
+		want   RequestLine
+		part   string // of the SyntaxError, "" if the line is valid
+		offset int
+	}{
+		{line: "GET / HTTP/1.1", want: RequestLine{"GET", "/", "HTTP/1.1", 1, 1}},
+		{line: "M-SEARCH * HTTP/1.1", want: RequestLine{"M-SEARCH", "*", "HTTP/1.1", 1, 1}},
+		{line: "GET http://a/b?c=d HTTP/1.0", want: RequestLine{"GET", "http://a/b?c=d", "HTTP/1.0", 1, 0}},
+		{line: "GET / HTTP/2.0", want: RequestLine{"GET", "/", "HTTP/2.0", 2, 0}},
+
+		{line: "", part: PartMethod, offset: 0},
+		{line: "GET", part: PartMethod, offset: 3},
+		{line: " GET / HTTP/1.1", part: PartMethod, offset: 0},
+		{line: "GE(T / HTTP/1.1", part: PartMethod, offset: 2},
+		{line: "GET\t/ HTTP/1.1", part: PartMethod, offset: 3},
+		{line: "GET  / HTTP/1.1", part: PartRequestTarget, offset: 4},
+		{line: "GET /a b HTTP/1.1", part: PartHTTPVersion, offset: 7},
+		{line: "GET /\x7f HTTP/1.1", part: PartRequestTarget, offset: 5},
+		{line: "GET /", part: PartRequestTarget, offset: 5},
+		{line: "GET / HTTP/1.1 ", part: PartHTTPVersion, offset: 6},
+		{line: "GET / http/1.1", part: PartHTTPVersion, offset: 6},
+		{line: "GET / HTTP/1", part: PartHTTPVersion, offset: 6},
+		{line: "GET / HTTP/11.0", part: PartHTTPVersion, offset: 6},
+		{line: "GET / HTTP/1,1", part: PartHTTPVersion, offset: 6},
+	}
+	for _, tt := range tests {
+		got, err := ParseRequestLine([]byte(tt.line))
+		if tt.part == "" {
+			if err != nil {
+				t.Errorf("ParseRequestLine(%q) error: %v", tt.line, err)
+			} else if got != tt.want {
+				t.Errorf("ParseRequestLine(%q) = %+v, want %+v", tt.line, got, tt.want)
+			}
+			continue
+		}
+		checkSyntaxError(t, "ParseRequestLine", tt.line, err, tt.part, tt.offset)
+	}
+}
+
+func TestParseHeaderField(t *testing.T) {
+	tests := []struct {
+		line        string
+		name, value string
+		part        string // of the SyntaxError, "" if the line is valid
+		offset      int
+	}{
+		{line: "Host: example.com", name: "Host", value: "example.com"},
+		{line: "Host:example.com", name: "Host", value: "example.com"},
+		{line: "X-A: \t a b \t ", name: "X-A", value: "a b"},
+		{line: "X-Empty:", name: "X-Empty", value: ""},
+		{line: "X-Empty:  ", name: "X-Empty", value: ""},
+		{line: "X-Obs-Text: caf\xc3\xa9", name: "X-Obs-Text", value: "caf\xc3\xa9"},
+		{line: "X-A: b:c", name: "X-A", value: "b:c"},
+
+		{line: "nocolon", part: PartFieldName, offset: 7},
+		{line: ": empty", part: PartFieldName, offset: 0},
+		{line: "Host : a", part: PartFieldName, offset: 4},
+		{line: "Host\t: a", part: PartFieldName, offset: 4},
+		{line: " folded", part: PartFieldName, offset: 0},
+		{line: "\tfolded", part: PartFieldName, offset: 0},
+		{line: "X A: b", part: PartFieldName, offset: 1},
+		{line: "X\x00A: b", part: PartFieldName, offset: 1},
+		{line: "X-A: b\x00c", part: PartFieldValue, offset: 6},
+		{line: "X-A: b\rc", part: PartFieldValue, offset: 6},
+		{line: "X-A: b\x7f", part: PartFieldValue, offset: 6},
+	}
+	for _, tt := range tests {
+		name, value, err := ParseHeaderField([]byte(tt.line))
+		if tt.part == "" {
+			if err != nil {
+				t.Errorf("ParseHeaderField(%q) error: %v", tt.line, err)
+			} else if name != tt.name || value != tt.value {
+				t.Errorf("ParseHeaderField(%q) = %q, %q, want %q, %q", tt.line, name, value, tt.name, tt.value)
+			}
+			continue
+		}
+		checkSyntaxError(t, "ParseHeaderField", tt.line, err, tt.part, tt.offset)
+	}
+}
+
+func TestValidToken(t *testing.T) {
+	for _, s := range []string{"GET", "chunked", "x-custom_1", "!#$%&'*+-.^_`|~"} {
+		if !ValidToken(s) {
+			t.Errorf("ValidToken(%q) = false, want true", s)
+		}
+	}
+	for _, s := range []string{"", "a b", "a,b", "a:b", "\"a\"", "caf\xc3\xa9", "a\x00"} {
+		if ValidToken(s) {
+			t.Errorf("ValidToken(%q) = true, want false", s)
+		}
+	}
+}
+
+func TestParseContentLength(t *testing.T) {
+	tests := []struct {
+		values       []string
+		want         int64
+		wantRepeated bool
+	}{
+		{[]string{"5"}, 5, false},
+		{[]string{"0"}, 0, false},
+		{[]string{"9223372036854775807"}, 1<<63 - 1, false},
+		{[]string{"5", "5"}, 5, true},
+		{[]string{"5, 5"}, 5, true},
+		{[]string{"5,5", "5"}, 5, true},
+	}
+	for _, tt := range tests {
+		n, repeated, err := ParseContentLength(tt.values)
+		if err != nil || n != tt.want || repeated != tt.wantRepeated {
+			t.Errorf("ParseContentLength(%q) = %d, %v, %v, want %d, %v, nil", tt.values, n, repeated, err, tt.want, tt.wantRepeated)
+		}
+	}
+
+	for _, values := range [][]string{
+		{""},
+		{"+5"},
+		{"-1"},
+		{"5 6"},
+		{"0x5"},
+		{"5", "6"},
+		{"5, 6"},
+		{"5,"},
+		{"9223372036854775808"},
+		{"18446744073709551616"},
+	} {
+		if n, _, err := ParseContentLength(values); err == nil {
+			t.Errorf("ParseContentLength(%q) = %d, want an error", values, n)
+		}
+	}
+}
+
+func TestReadLine(t *testing.T) {
+	tests := []struct {
+		input  string
+		lines  []string
+		err    error  // after the lines
+		part   string // of the SyntaxError instead of err
+		offset int
+	}{
+		{input: "a\r\nbc\r\n\r\n", lines: []string{"a", "bc", ""}, err: io.EOF},
+		{input: "", err: io.EOF},
+		{input: "a\r\nbc", lines: []string{"a"}, err: io.ErrUnexpectedEOF},
+		{input: "a\r\nb\n", lines: []string{"a"}, part: PartLine, offset: 1},
+		{input: "\n", part: PartLine, offset: 0},
+		{input: "a\rb\r\n", part: PartLine, offset: 1},
+		{input: "ab\r\r\n", part: PartLine, offset: 2},
+		// Longer than the bufio.Reader's buffer.
+		{input: strings.Repeat("x", 100) + "\r\n", lines: []string{strings.Repeat("x", 100)}, err: io.EOF},
+	}
+	for _, tt := range tests {
+		br := bufio.NewReaderSize(strings.NewReader(tt.input), 16)
+		for _, want := range tt.lines {
+			line, err := ReadLine(br)
+			if err != nil || string(line) != want {
+				t.Errorf("ReadLine of %q = %q, %v, want %q", tt.input, line, err, want)
+			}
+		}
+		_, err := ReadLine(br)
+		if tt.part != "" {
+			checkSyntaxError(t, "ReadLine", tt.input, err, tt.part, tt.offset)
+		} else if err != tt.err {
+			t.Errorf("ReadLine of %q error = %v, want %v", tt.input, err, tt.err)
+		}
+	}
+}
+
+func checkSyntaxError(t *testing.T, fn, input string, err error, part string, offset int) {
+	t.Helper()
+	var synErr *SyntaxError
+	if !errors.As(err, &synErr) {
+		t.Errorf("%s(%q) error = %v, want a *SyntaxError", fn, input, err)
+		return
+	}
+	if synErr.Part != part || synErr.Offset != offset {
+		t.Errorf("%s(%q) error = %v, want %s at offset %d", fn, input, err, part, offset)
+	}
+}
+
+func TestParseChunkLine(t *testing.T) {
+	tests := []struct {
+		line   string
+		size   int64
+		exts   []ChunkExtension
+		part   string // of the SyntaxError, "" if the line is valid
+		offset int
+	}{
+		{line: "0", size: 0},
+		{line: "1a", size: 26},
+		{line: "FF", size: 255},
+		{line: "7fffffffffffffff", size: 1<<63 - 1},
+		{line: "000000000000000a", size: 10},
+		{line: "5;a", size: 5, exts: []ChunkExtension{{"a", ""}}},
+		{line: "5;a=b;c=\"d e\"", size: 5, exts: []ChunkExtension{{"a", "b"}, {"c", "d e"}}},
+		{line: "5;q=\"\\\"x\\\\\"", size: 5, exts: []ChunkExtension{{"q", "\"x\\"}}},
+		{line: "5;q=\"\"", size: 5, exts: []ChunkExtension{{"q", ""}}},
+
+		{line: "", part: PartChunkSize, offset: 0},
+		{line: "x", part: PartChunkSize, offset: 0},
+		{line: "-1", part: PartChunkSize, offset: 0},
+		{line: " 5", part: PartChunkSize, offset: 0},
+		{line: "8000000000000000", part: PartChunkSize, offset: 15},
+		{line: "00000000000000001", part: PartChunkSize, offset: 16},
+		{line: "5 ", part: PartChunkExt, offset: 1},
+		{line: "5x", part: PartChunkExt, offset: 1},
+		{line: "5;", part: PartChunkExt, offset: 2},
+		{line: "5; a", part: PartChunkExt, offset: 2},
+		{line: "5;a =b", part: PartChunkExt, offset: 3},
+		{line: "5;a= b", part: PartChunkExt, offset: 4},
+		{line: "5;a=", part: PartChunkExt, offset: 4},
+		{line: "5;a=\"b", part: PartChunkExt, offset: 6},
+		{line: "5;a=\"b\\", part: PartChunkExt, offset: 7},
+		{line: "5;a=\"\x00\"", part: PartChunkExt, offset: 5},
+		{line: "5;a=\"b\"c", part: PartChunkExt, offset: 7},
+	}
+	for _, tt := range tests {
+		size, exts, err := ParseChunkLine([]byte(tt.line))
+		if tt.part == "" {
+			if err != nil {
+				t.Errorf("ParseChunkLine(%q) error: %v", tt.line, err)
+			} else if size != tt.size || !reflect.DeepEqual(exts, tt.exts) {
+				t.Errorf("ParseChunkLine(%q) = %d, %q, want %d, %q", tt.line, size, exts, tt.size, tt.exts)
+			}
+			continue
+		}
+		checkSyntaxError(t, "ParseChunkLine", tt.line, err, tt.part, tt.offset)
+	}
+}
+
+func TestReadLineLimit(t *testing.T) {
+	br := bufio.NewReaderSize(strings.NewReader("abcd\r\nabcde\r\n"), 16)
+	if line, err := ReadLineLimit(br, 4); err != nil || string(line) != "abcd" {
+		t.Errorf("ReadLineLimit = %q, %v, want %q", line, err, "abcd")
+	}
+	if _, err := ReadLineLimit(br, 4); err != ErrLineTooLong {
+		t.Errorf("ReadLineLimit error = %v, want %v", err, ErrLineTooLong)
+	}
+
+	// The limit is hit before an endless line is buffered.
+	endless := io.MultiReader(strings.NewReader("x"), infiniteReader{})
+	if _, err := ReadLineLimit(bufio.NewReaderSize(endless, 16), 100); err != ErrLineTooLong {
+		t.Errorf("ReadLineLimit of an endless line error = %v, want %v", err, ErrLineTooLong)
+	}
+}
+
+type infiniteReader struct{}
+
+func (infiniteReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 'x'
+	}
+	return len(p), nil
+}
@@ -0,0 +1,86 @@
+// Package servertest has helpers for comparing what a handler sees of a
+// request on our servers with what it sees on net/http.
+package servertest
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// Request is what a handler can see of a request. It is taken after the
+// body has been read, so any trailers are in.
+type Request struct {
+	Method           string
+	URL              string
+	RequestURI       string
+	Proto            string
+	ProtoMajor       int
+	ProtoMinor       int
+	Host             string
+	Header           http.Header
+	Body             string
+	NoBody           bool
+	ContentLength    int64
+	TransferEncoding []string
+	Close            bool
+	Trailer          http.Header
+	HasRemoteAddr    bool
+	HasServer        bool
+	HasLocalAddr     bool
+}
+
+// Snapshot reads r's body and records what the handler saw.
+func Snapshot(r *http.Request) Request {
+	noBody := r.Body == http.NoBody
+	body, _ := io.ReadAll(r.Body)
+	return Request{
+		Method:           r.Method,
+		URL:              r.URL.String(),
+		RequestURI:       r.RequestURI,
+		Proto:            r.Proto,
+		ProtoMajor:       r.ProtoMajor,
+		ProtoMinor:       r.ProtoMinor,
+		Host:             r.Host,
+		Header:           r.Header,
+		Body:             string(body),
+		NoBody:           noBody,
+		ContentLength:    r.ContentLength,
+		TransferEncoding: r.TransferEncoding,
+		Close:            r.Close,
+		Trailer:          r.Trailer,
+		HasRemoteAddr:    r.RemoteAddr != "",
+		HasServer:        r.Context().Value(http.ServerContextKey) != nil,
+		HasLocalAddr:     r.Context().Value(http.LocalAddrContextKey) != nil,
+	}
+}
+
+// Capture sends raw to addr and returns the snapshot the handler sent on
+// got.
+func Capture(t *testing.T, addr, raw string, got <-chan Request) Request {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	io.WriteString(conn, raw)
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	select {
+	case snap := <-got:
+		return snap
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler was not called")
+	}
+	return Request{}
+}